@@ -0,0 +1,603 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	templates "server/html"
+	"server/types"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// candleIntervals are the candle resolutions. Candles older than keep are
+// dropped; daily candles outlive the snapshots they were built from.
+var candleIntervals = []struct {
+	name string
+	size time.Duration
+	keep time.Duration
+}{
+	{"5m", 5 * time.Minute, 2 * 24 * time.Hour},
+	{"1h", time.Hour, 90 * 24 * time.Hour},
+	{"1d", 24 * time.Hour, 2 * 365 * 24 * time.Hour},
+}
+
+// maxJournaledFolds is how many snapshots are appended to the candle
+// journal before the full candles are written again, a day of snapshots
+// every 15 minutes.
+const maxJournaledFolds = 96
+
+var fillOptions = []string{"none", "previous"}
+
+const (
+	defaultCandles = 100
+	maxCandles     = 1000
+)
+
+func candleIntervalNames() []string {
+	names := make([]string, len(candleIntervals))
+	for i, iv := range candleIntervals {
+		names[i] = iv.name
+	}
+	return names
+}
+
+func candleIntervalSize(name string) time.Duration {
+	for _, iv := range candleIntervals {
+		if iv.name == name {
+			return iv.size
+		}
+	}
+	return 0
+}
+
+// candleSample is the last snapshot value of one coin in one currency, the
+// base of the next volume estimate.
+type candleSample struct {
+	Time      time.Time `json:"time"`
+	Volume24h float64   `json:"volume_24h"`
+}
+
+// candleStore aggregates snapshot prices into candles. Every new snapshot
+// is folded into the open candles, nothing is recomputed. The candles are
+// kept in cfg.DataDir/candles.json.gz, and the candles and samples every
+// later snapshot changed are appended to candles.journal, so a snapshot
+// writes only what it changed and a restart only folds the snapshots taken
+// since.
+type candleStore struct {
+	path    string
+	journal string
+
+	mu     sync.Mutex
+	folded time.Time                 // time of the newest snapshot folded in
+	series map[string][]types.Candle // by candleKey, oldest first
+	last   map[string]candleSample   // by sampleKey
+
+	// saveMu orders the writes to the files, which happen outside mu so
+	// readers are not held up; journaled counts the snapshots in the
+	// journal
+	saveMu    sync.Mutex
+	journaled int
+}
+
+var candles *candleStore
+
+type candleFile struct {
+	Folded time.Time                 `json:"folded"`
+	Series map[string][]types.Candle `json:"series"`
+	Last   map[string]candleSample   `json:"last"`
+}
+
+// candleTail is one journal line, what folding the snapshot taken at
+// Folded changed: the newest candle of every series it touched and the
+// samples it took.
+type candleTail struct {
+	Folded  time.Time               `json:"folded"`
+	Candles map[string]types.Candle `json:"candles"`
+	Last    map[string]candleSample `json:"last"`
+}
+
+func candleKey(interval, currency string, id int) string {
+	return fmt.Sprintf("%s|%s|%d", interval, currency, id)
+}
+
+func sampleKey(currency string, id int) string {
+	return fmt.Sprintf("%s|%d", currency, id)
+}
+
+// openCandleStore loads the stored candles, replays the journal and folds
+// in the snapshots that are newer than both.
+func openCandleStore(dir string, snaps *snapshotStore) (*candleStore, error) {
+	s := &candleStore{
+		path:    filepath.Join(dir, "candles.json.gz"),
+		journal: filepath.Join(dir, "candles.journal"),
+		series:  make(map[string][]types.Candle),
+		last:    make(map[string]candleSample),
+	}
+
+	f, err := os.Open(s.path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		defer f.Close()
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		var stored candleFile
+		if err := json.NewDecoder(zr).Decode(&stored); err != nil {
+			return nil, err
+		}
+		s.folded = stored.Folded
+		if stored.Series != nil {
+			s.series = stored.Series
+		}
+		if stored.Last != nil {
+			s.last = stored.Last
+		}
+	}
+
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+
+	missed := snaps.since(s.folded)
+	if len(missed) == 0 {
+		return s, nil
+	}
+	start := time.Now()
+	for _, t := range missed {
+		snap, err := snaps.load(t)
+		if err != nil {
+			return nil, err
+		}
+		s.fold(snap)
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	slog.Info("Folded snapshots into candles", "snapshots", len(missed), "duration", time.Since(start))
+	return s, nil
+}
+
+// replay applies the journal lines newer than the stored candles. A crash
+// can leave the last line half written; it is dropped, the snapshot is
+// folded again from the snapshot store.
+func (s *candleStore) replay() error {
+	f, err := os.Open(s.journal)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	for {
+		var tail candleTail
+		if err := dec.Decode(&tail); err != nil {
+			if !errors.Is(err, io.EOF) {
+				slog.Warn("Dropped the end of the candle journal", "err", err)
+			}
+			return nil
+		}
+		s.journaled++
+		if !tail.Folded.After(s.folded) {
+			continue
+		}
+
+		for key, c := range tail.Candles {
+			series := s.series[key]
+			if n := len(series); n > 0 && series[n-1].Time.Equal(c.Time) {
+				series[n-1] = c
+			} else {
+				series = append(series, c)
+			}
+			s.series[key] = series
+		}
+		for key, sample := range tail.Last {
+			s.last[key] = sample
+		}
+		s.folded = tail.Folded
+		s.prune(tail.Folded)
+	}
+}
+
+// add folds snap in and stores what it changed.
+func (s *candleStore) add(snap types.Snapshot) error {
+	tail, ok := s.fold(snap)
+	if !ok {
+		return nil
+	}
+
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	if s.journaled >= maxJournaledFolds {
+		return s.compact()
+	}
+	b, err := json.Marshal(tail)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.journal, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	_, werr := f.Write(append(b, '\n'))
+	serr := f.Sync()
+	if err := errors.Join(werr, serr, f.Close()); err != nil {
+		return err
+	}
+	s.journaled++
+	return nil
+}
+
+// sampleGap is the longest step between two snapshots that a volume
+// estimate is made over; after a longer pause volume counts from the next
+// snapshot on.
+func sampleGap() time.Duration {
+	return max(2*cfg.SnapshotInterval, 30*time.Minute)
+}
+
+// fold adds the quotes of snap to the candles it falls into and returns
+// what changed. Snapshots not newer than the last one folded are ignored,
+// ok is false for them.
+//
+// Volume is taken from the change in the rolling 24h volume since the last
+// snapshot: the volume traded in the step is that change plus what left the
+// 24h window, estimated as an even share of the previous 24h volume.
+func (s *candleStore) fold(snap types.Snapshot) (tail candleTail, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !snap.Time.After(s.folded) {
+		return candleTail{}, false
+	}
+	tail = candleTail{
+		Folded:  snap.Time,
+		Candles: make(map[string]types.Candle),
+		Last:    make(map[string]candleSample),
+	}
+
+	for _, l := range snap.Listings {
+		for currency, q := range l.Quote {
+			if q.Price <= 0 {
+				continue
+			}
+
+			var volume float64
+			sk := sampleKey(currency, l.ID)
+			if prev, ok := s.last[sk]; ok {
+				if step := snap.Time.Sub(prev.Time); step > 0 && step <= sampleGap() {
+					left := prev.Volume24h * step.Hours() / 24
+					volume = max(0, q.Volume24h-prev.Volume24h+left)
+				}
+			}
+			s.last[sk] = candleSample{Time: snap.Time, Volume24h: q.Volume24h}
+			tail.Last[sk] = s.last[sk]
+
+			for _, iv := range candleIntervals {
+				key := candleKey(iv.name, currency, l.ID)
+				series := addTick(s.series[key], snap.Time.Truncate(iv.size), q.Price, volume)
+				s.series[key] = series
+				tail.Candles[key] = series[len(series)-1]
+			}
+		}
+	}
+	s.folded = snap.Time
+	s.prune(snap.Time)
+	return tail, true
+}
+
+// addTick adds one price to the candle starting at open, opening it when
+// it is newer than the last candle of series.
+func addTick(series []types.Candle, open time.Time, price, volume float64) []types.Candle {
+	if n := len(series); n > 0 && series[n-1].Time.Equal(open) {
+		c := &series[n-1]
+		c.High = max(c.High, price)
+		c.Low = min(c.Low, price)
+		c.Close = price
+		c.Volume += volume
+		c.Samples++
+		return series
+	}
+	return append(series, types.Candle{
+		Time:    open,
+		Open:    price,
+		High:    price,
+		Low:     price,
+		Close:   price,
+		Volume:  volume,
+		Samples: 1,
+	})
+}
+
+// prune drops the candles past their interval's keep and the samples too
+// old to estimate volume from. Callers hold s.mu.
+func (s *candleStore) prune(now time.Time) {
+	for _, iv := range candleIntervals {
+		cutoff := now.Add(-iv.keep)
+		prefix := iv.name + "|"
+		for key, series := range s.series {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			i := sort.Search(len(series), func(i int) bool { return !series[i].Time.Before(cutoff) })
+			switch {
+			case i == len(series):
+				delete(s.series, key)
+			case i > 0:
+				s.series[key] = append([]types.Candle{}, series[i:]...)
+			}
+		}
+	}
+
+	for key, sample := range s.last {
+		if now.Sub(sample.Time) > sampleGap() {
+			delete(s.last, key)
+		}
+	}
+}
+
+// flush compacts the journal on shutdown, so the next start has no lines
+// to replay.
+func (s *candleStore) flush(context.Context) error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	if s.journaled == 0 {
+		return nil
+	}
+	return s.compact()
+}
+
+// compact writes all candles and empties the journal. Only the copy is
+// made under s.mu, encoding runs without it. The journal is emptied after
+// the candles are written, a crash in between leaves lines replay skips.
+// Callers hold s.saveMu, except while the store is opened.
+func (s *candleStore) compact() error {
+	s.mu.Lock()
+	stored := candleFile{
+		Folded: s.folded,
+		Series: make(map[string][]types.Candle, len(s.series)),
+		Last:   make(map[string]candleSample, len(s.last)),
+	}
+	for key, series := range s.series {
+		stored.Series[key] = append([]types.Candle{}, series...)
+	}
+	for key, sample := range s.last {
+		stored.Last[key] = sample
+	}
+	s.mu.Unlock()
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(stored); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path, buf.Bytes()); err != nil {
+		return err
+	}
+	if err := os.Remove(s.journal); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	s.journaled = 0
+	return nil
+}
+
+// window returns the candles of one series starting in [from, to), the
+// last candle before from, nil when there is none, and the time of the
+// newest snapshot folded in.
+func (s *candleStore) window(interval, currency string, id int, from, to time.Time) ([]types.Candle, *types.Candle, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	series := s.series[candleKey(interval, currency, id)]
+	i := sort.Search(len(series), func(i int) bool { return !series[i].Time.Before(from) })
+	j := sort.Search(len(series), func(i int) bool { return !series[i].Time.Before(to) })
+
+	var prev *types.Candle
+	if i > 0 {
+		c := series[i-1]
+		prev = &c
+	}
+	return append([]types.Candle{}, series[i:j]...), prev, s.folded
+}
+
+// lastFolded returns the time of the newest snapshot folded in. It changes
+// whenever candles change, so results computed from them can be cached
+// against it.
+func (s *candleStore) lastFolded() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.folded
+}
+
+// candlesHandler serves /api/candles?coin=&interval=&from=&to=. Coins are
+// resolved against the newest snapshot. Intervals without a snapshot are
+// reported as gaps and, with fill=previous, filled with the previous close.
+func candlesHandler(w http.ResponseWriter, r *http.Request) {
+	p, format, err := clientParams(r)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	ref := p.str("coin")
+	if ref == "" {
+		p.fail("coin", "is required")
+	} else if !validCoinRef(ref) {
+		p.fail("coin", "must be a CoinMarketCap ID, slug or symbol")
+	}
+	interval := p.enum("interval", "1h", candleIntervalNames(), false)
+	currency := snapshotCurrency(p)
+	fill := p.enum("fill", "none", fillOptions, false)
+	from, to := candleRange(p, interval)
+	if err := p.err(); err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	if !allowRequest(w, r, format) {
+		return
+	}
+
+	coin, err := snapshotCoin(ref)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	found, prev, folded := candles.window(interval, currency, coin.ID, from, to)
+	series := types.CandleSeries{
+		ID:       coin.ID,
+		Name:     coin.Name,
+		Symbol:   coin.Symbol,
+		Slug:     coin.Slug,
+		Currency: currency,
+		Interval: interval,
+		From:     from,
+		To:       to,
+	}
+	// candles after the newest snapshot are not missing, there is no data
+	// for them yet
+	until := folded.Truncate(candleIntervalSize(interval)).Add(candleIntervalSize(interval))
+	if until.After(to) {
+		until = to
+	}
+	series.Candles, series.Gaps = fillGaps(found, prev, from, until, candleIntervalSize(interval), fill == "previous")
+
+	if format == "json" {
+		writeJSON(w, http.StatusOK, series)
+		return
+	}
+	renderHTML(w, r, http.StatusOK, templates.Candles, series)
+}
+
+// snapshotCurrency reads currency, one of cfg.SnapshotCurrencies, USD when
+// absent.
+func snapshotCurrency(p *params) string {
+	currency := strings.ToUpper(p.str("currency"))
+	if currency == "" {
+		return "USD"
+	}
+	if !contains(cfg.SnapshotCurrencies, currency) {
+		p.fail("currency", "must be one of the stored currencies: %s", strings.Join(cfg.SnapshotCurrencies, ", "))
+	}
+	return currency
+}
+
+// candleRange reads from and to, aligned to the candle interval. to
+// defaults to now and from to defaultCandles intervals before to.
+func candleRange(p *params, interval string) (from, to time.Time) {
+	size := candleIntervalSize(interval)
+
+	to = p.timestamp("to")
+	if to.IsZero() {
+		to = time.Now().UTC()
+	}
+	// the candle containing to is included
+	to = to.Truncate(size).Add(size)
+
+	from = p.timestamp("from")
+	if from.IsZero() {
+		return to.Add(-defaultCandles * size), to
+	}
+	from = from.Truncate(size)
+
+	switch {
+	case !from.Before(to):
+		p.fail("from", "must be before to")
+	case to.Sub(from) > maxCandles*size:
+		p.fail("from", "the range covers more than %d %s candles", maxCandles, interval)
+	}
+	return from, to
+}
+
+// latestSnapshot loads the newest snapshot.
+func latestSnapshot() (types.Snapshot, error) {
+	t, ok := snapshots.latest()
+	if !ok {
+		return types.Snapshot{}, errNotFound("No listing snapshot is stored yet.")
+	}
+	snap, err := snapshots.load(t)
+	if err != nil {
+		return types.Snapshot{}, errInternal(err)
+	}
+	return snap, nil
+}
+
+// snapshotCoin resolves ref against the newest snapshot.
+func snapshotCoin(ref string) (types.CryptoListing, error) {
+	snap, err := latestSnapshot()
+	if err != nil {
+		return types.CryptoListing{}, err
+	}
+	coin, ok := findListing(snap.Listings, ref)
+	if !ok {
+		return types.CryptoListing{}, errNotFound(fmt.Sprintf("No coin %q in the newest snapshot.", ref))
+	}
+	return coin, nil
+}
+
+// fillGaps walks the candle times from from to to and lists the runs
+// without a candle that last at least sampleGap; shorter runs only mean the
+// interval is finer than the snapshots. With fill each missing candle
+// repeats the close before it; missing candles before the first one known
+// stay missing.
+func fillGaps(found []types.Candle, prev *types.Candle, from, to time.Time, size time.Duration, fill bool) ([]types.Candle, []types.CandleGap) {
+	out := []types.Candle{}
+	var runs []types.CandleGap
+
+	i := 0
+	for t := from; t.Before(to); t = t.Add(size) {
+		if i < len(found) && found[i].Time.Equal(t) {
+			out = append(out, found[i])
+			prev = &found[i]
+			i++
+			continue
+		}
+
+		if n := len(runs); n > 0 && runs[n-1].To.Equal(t) {
+			runs[n-1].To = t.Add(size)
+		} else {
+			runs = append(runs, types.CandleGap{From: t, To: t.Add(size)})
+		}
+		if fill && prev != nil {
+			out = append(out, types.Candle{
+				Time:   t,
+				Open:   prev.Close,
+				High:   prev.Close,
+				Low:    prev.Close,
+				Close:  prev.Close,
+				Filled: true,
+			})
+		}
+	}
+
+	gaps := []types.CandleGap{}
+	for _, g := range runs {
+		if g.To.Sub(g.From) >= sampleGap() {
+			gaps = append(gaps, g)
+		}
+	}
+	return out, gaps
+}
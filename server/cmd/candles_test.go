@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"server/types"
+	"testing"
+	"time"
+)
+
+func TestCandleStoreReopen(t *testing.T) {
+	tests := []struct {
+		name      string
+		snapshots int
+		// torn is appended to the journal, a line cut short by a crash
+		torn string
+	}{
+		{name: "journal only", snapshots: 30},
+		{name: "compacted and journal", snapshots: maxJournaledFolds + 20},
+		{name: "torn journal line", snapshots: 30, torn: `{"folded":"2024-01-`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			snaps, err := openSnapshotStore(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err := openCandleStore(dir, snaps)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			for k := 0; k < tt.snapshots; k++ {
+				snap := types.Snapshot{Time: start.Add(time.Duration(k) * 7 * time.Minute)}
+				for id := 1; id <= 3; id++ {
+					snap.Listings = append(snap.Listings, types.CryptoListing{ID: id, Quote: map[string]types.Quote{
+						"USD": {Price: float64(100*id + k%11), Volume24h: float64(1000*id + 10*k)},
+					}})
+				}
+				if err := s.add(snap); err != nil {
+					t.Fatalf("add() error = %v", err)
+				}
+			}
+			if s.journaled >= maxJournaledFolds {
+				t.Errorf("journaled = %d, want fewer than %d", s.journaled, maxJournaledFolds)
+			}
+
+			if tt.torn != "" {
+				f, err := os.OpenFile(s.journal, os.O_APPEND|os.O_WRONLY, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				f.WriteString(tt.torn)
+				f.Close()
+			}
+
+			reopened, err := openCandleStore(dir, snaps)
+			if err != nil {
+				t.Fatalf("openCandleStore() error = %v", err)
+			}
+			if !reopened.folded.Equal(s.folded) {
+				t.Errorf("folded = %v, want %v", reopened.folded, s.folded)
+			}
+			if !reflect.DeepEqual(reopened.series, s.series) {
+				t.Errorf("reopened series differ from the folded ones")
+			}
+			if !reflect.DeepEqual(reopened.last, s.last) {
+				t.Errorf("last = %v, want %v", reopened.last, s.last)
+			}
+		})
+	}
+}
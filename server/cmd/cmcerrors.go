@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"server/types"
+	"strconv"
+	"time"
+)
+
+const (
+	codeUpstreamAuth      = "upstream_auth_failed"
+	codeUpstreamRateLimit = "upstream_rate_limited"
+)
+
+// CoinMarketCap Status.ErrorCode values, see
+// https://coinmarketcap.com/api/documentation/v1/#section/Errors-and-Rate-Limits
+const (
+	cmcBadRequest          = 400
+	cmcKeyInvalid          = 1001
+	cmcKeyMissing          = 1002
+	cmcPlanRequiresPayment = 1003
+	cmcPlanPaymentExpired  = 1004
+	cmcKeyRequired         = 1005
+	cmcPlanUnauthorized    = 1006
+	cmcKeyDisabled         = 1007
+	cmcMinuteRateLimit     = 1008
+	cmcDailyRateLimit      = 1009
+	cmcMonthlyRateLimit    = 1010
+	cmcIPRateLimit         = 1011
+)
+
+var upstreamAlerts = newCounterVec("upstream_alerts_total",
+	"Upstream failures that need an operator, such as a rejected provider API key.",
+	"provider", "reason")
+
+// cmcError maps a CoinMarketCap failure to our own taxonomy, using the
+// Status.ErrorCode from the body when there is one and the HTTP status
+// otherwise. It returns nil for a successful response.
+//
+// Upstream problems with our own credentials or plan are never passed on as
+// 401/403, our client's key is fine; they become 502 and alert the
+// operator. Exhausted credits become 503 with Retry-After, and a rejected
+// parameter is the client's mistake, so it stays 400.
+func cmcError(ctx context.Context, httpStatus int, st types.Status) *apiError {
+	code := st.ErrorCode
+	if code == 0 && httpStatus == http.StatusOK {
+		return nil
+	}
+
+	detail := st.ErrorMessage
+	if detail == "" {
+		detail = "coinmarketcap answered with status " + strconv.Itoa(httpStatus) + "."
+	}
+
+	switch {
+	case code == cmcKeyInvalid, code == cmcKeyMissing, code == cmcKeyRequired,
+		code == cmcKeyDisabled, code == cmcPlanUnauthorized,
+		code == cmcPlanRequiresPayment, code == cmcPlanPaymentExpired,
+		code == 0 && (httpStatus == http.StatusUnauthorized || httpStatus == http.StatusForbidden || httpStatus == http.StatusPaymentRequired):
+		alertOperator(ctx, "coinmarketcap", "auth", code, st.ErrorMessage)
+		return &apiError{
+			Status:         http.StatusBadGateway,
+			Code:           codeUpstreamAuth,
+			Title:          "Data provider rejected our credentials",
+			Detail:         "coinmarketcap did not accept this server's API key or plan. The operator has been alerted.",
+			Provider:       "coinmarketcap",
+			UpstreamStatus: httpStatus,
+		}
+
+	case code == cmcMinuteRateLimit, code == cmcDailyRateLimit,
+		code == cmcMonthlyRateLimit, code == cmcIPRateLimit,
+		code == 0 && httpStatus == http.StatusTooManyRequests:
+		return &apiError{
+			Status:         http.StatusServiceUnavailable,
+			Code:           codeUpstreamRateLimit,
+			Title:          "Data provider limit reached",
+			Detail:         "coinmarketcap usage limit reached: " + detail,
+			Provider:       "coinmarketcap",
+			UpstreamStatus: httpStatus,
+			RetryAfter:     cmcRetryAfter(code, time.Now().UTC()),
+		}
+
+	case code == cmcBadRequest, code == 0 && httpStatus == http.StatusBadRequest:
+		return &apiError{
+			Status:         http.StatusBadRequest,
+			Code:           codeInvalidParameters,
+			Title:          "Invalid parameters",
+			Detail:         "coinmarketcap rejected the request: " + detail,
+			Provider:       "coinmarketcap",
+			UpstreamStatus: httpStatus,
+		}
+	}
+
+	apiErr := errUpstreamStatus("coinmarketcap", httpStatus)
+	if st.ErrorMessage != "" {
+		apiErr.Detail = "coinmarketcap error " + strconv.Itoa(code) + ": " + st.ErrorMessage
+	}
+	return apiErr
+}
+
+// cmcRetryAfter estimates, in seconds, when a CoinMarketCap limit resets.
+// Minute and IP limits roll over within a minute, daily limits at UTC
+// midnight and monthly limits at the start of the next UTC month.
+func cmcRetryAfter(code int, now time.Time) int {
+	var reset time.Time
+	switch code {
+	case cmcDailyRateLimit:
+		reset = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	case cmcMonthlyRateLimit:
+		reset = time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return 60
+	}
+	return int(reset.Sub(now).Seconds()) + 1
+}
+
+// alertOperator records a failure a human has to fix. It is logged at error
+// level with alert=true, which is what log based alerting should match on,
+// and counted in upstream_alerts_total.
+func alertOperator(ctx context.Context, provider, reason string, code int, message string) {
+	upstreamAlerts.inc(provider, reason)
+	slog.ErrorContext(ctx, "Operator action required",
+		"alert", true,
+		"provider", provider,
+		"reason", reason,
+		"upstream_error_code", code,
+		"upstream_error_message", message,
+	)
+}
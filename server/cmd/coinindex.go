@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"server/types"
+	"sync"
+	"time"
+)
+
+// coinIndex caches the CoinGecko coin list. The list is several megabytes
+// and changes rarely, so it is fetched at most once per TTL instead of on
+// every listings request.
+type coinIndex struct {
+	mu      sync.Mutex
+	coins   []types.Coin
+	fetched time.Time
+}
+
+var coinList = &coinIndex{}
+
+func (c *coinIndex) get(ctx context.Context, client *http.Client) ([]types.Coin, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.coins != nil && time.Since(c.fetched) < cfg.CoinIndexTTL {
+		cacheRequests.inc("coin_index", "hit")
+		return c.coins, nil
+	}
+	cacheRequests.inc("coin_index", "miss")
+
+	coins, err := createCoinGeckoRequest(ctx, client)
+	if err != nil {
+		// serve a stale list rather than nothing
+		if c.coins != nil {
+			return c.coins, nil
+		}
+		return coins, err
+	}
+
+	c.coins = coins
+	c.fetched = time.Now()
+	return coins, nil
+}
+
+// cached returns the list from the last fetch and its time without
+// fetching, nil before the first fetch.
+func (c *coinIndex) cached() ([]types.Coin, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.coins, c.fetched
+}
+
+// loaded reports whether the list has been fetched at least once.
+func (c *coinIndex) loaded() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.coins != nil
+}
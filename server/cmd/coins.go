@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	templates "server/html"
+	"server/types"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// coinHistory is the interval and length of the price series a coin detail
+// includes.
+const (
+	coinHistoryInterval = "1h"
+	coinHistoryPeriod   = 7 * 24 * time.Hour
+)
+
+// maxConvert bounds how many quote currencies one request may ask for;
+// every extra currency costs CMC credits.
+const maxConvert = 5
+
+// coinHandler serves /api/coins/{coin}, where coin is a CMC ID, a slug or a
+// symbol. Numbers are IDs; anything else is tried as a slug first and then
+// as a symbol, so "bitcoin" and "BTC" both work.
+func coinHandler(w http.ResponseWriter, r *http.Request) {
+	ref := strings.TrimPrefix(r.URL.Path, "/api/coins/")
+
+	p, format, err := clientParams(r)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	if !validCoinRef(ref) {
+		p.fail("coin", "must be a CoinMarketCap ID, slug or symbol")
+	}
+	convert := parseCurrencies(p, "convert")
+	if err := p.err(); err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	if !allowRequest(w, r, format) {
+		return
+	}
+
+	coin, err := lookupCoin(r.Context(), upstreamClient, ref, convert)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	detail := types.CoinDetail{
+		Coin:       coin,
+		Currencies: convert,
+		Caller:     types.Caller{APIKey: p.str("api-key")},
+	}
+
+	now := time.Now()
+	detail.History, _, _ = candles.window(coinHistoryInterval, "USD", coin.ID, now.Add(-coinHistoryPeriod), now)
+	if len(detail.History) == 0 {
+		detail.History = nil
+	}
+
+	// the cross-check is best effort, a missing coin list only means the
+	// lookup falls back to the CMC name
+	coins, _ := coinList.get(r.Context(), upstreamClient)
+	detail.CoinGeckoID = findCoinID(coins, coin.Name)
+	detail.PriceOfCoinOtherApi = createCoinGeckoPriceRequest(r.Context(), detail.CoinGeckoID, upstreamClient)
+
+	if format == "json" {
+		writeJSON(w, http.StatusOK, detail)
+		return
+	}
+
+	renderHTML(w, r, http.StatusOK, templates.Coin, detail)
+}
+
+func validCoinRef(ref string) bool {
+	if ref == "" || len(ref) > 64 {
+		return false
+	}
+	for _, c := range ref {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseCurrencies reads a comma separated list of currency codes, USD when
+// absent.
+func parseCurrencies(p *params, name string) []string {
+	codes := p.list(name)
+	if len(codes) == 0 {
+		return []string{"USD"}
+	}
+	if len(codes) > maxConvert {
+		p.fail(name, "at most %d currencies", maxConvert)
+		return nil
+	}
+
+	for i, c := range codes {
+		c = strings.ToUpper(c)
+		if !isCurrencyCode(c) {
+			p.fail(name, "%q is not a currency code", codes[i])
+			return nil
+		}
+		codes[i] = c
+	}
+	return codes
+}
+
+func isCurrencyCode(c string) bool {
+	if len(c) < 2 || len(c) > 5 {
+		return false
+	}
+	for _, r := range c {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupCoin resolves ref to a single listing with quotes in convert.
+func lookupCoin(ctx context.Context, client *http.Client, ref string, convert []string) (types.CryptoListing, error) {
+	if _, err := strconv.Atoi(ref); err == nil {
+		coin, err := createCoinMarketCapQuoteRequest(ctx, client, "id", ref, convert)
+		return coin, notFoundIfRejected(err, ref)
+	}
+
+	coin, err := createCoinMarketCapQuoteRequest(ctx, client, "slug", strings.ToLower(ref), convert)
+	if !isRejectedParameter(err) {
+		return coin, err
+	}
+
+	coin, err = createCoinMarketCapQuoteRequest(ctx, client, "symbol", strings.ToUpper(ref), convert)
+	return coin, notFoundIfRejected(err, ref)
+}
+
+// isRejectedParameter reports whether CMC refused the lookup value itself,
+// which is how it answers for an unknown id, slug or symbol.
+func isRejectedParameter(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.Code == codeInvalidParameters && apiErr.Provider == "coinmarketcap"
+}
+
+func notFoundIfRejected(err error, ref string) error {
+	if isRejectedParameter(err) {
+		return errNotFound("No coin with ID, slug or symbol " + strconv.Quote(ref) + ".")
+	}
+	return err
+}
+
+// createCoinMarketCapQuoteRequest fetches the latest quote of one coin,
+// selected by field (id, slug or symbol).
+func createCoinMarketCapQuoteRequest(ctx context.Context, client *http.Client, field, value string, convert []string) (types.CryptoListing, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://pro-api.coinmarketcap.com/v1/cryptocurrency/quotes/latest", nil)
+	if err != nil {
+		return types.CryptoListing{}, errInternal(err)
+	}
+
+	q := req.URL.Query()
+	q.Add(field, value)
+	q.Add("convert", strings.Join(convert, ","))
+	req.URL.RawQuery = q.Encode()
+
+	req.Header.Set("Accepts", "application/json")
+	req.Header.Add("X-CMC_PRO_API_KEY", cfg.CMCAPIKey)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return types.CryptoListing{}, errUpstreamUnavailable("coinmarketcap", err)
+	}
+	defer resp.Body.Close()
+
+	var responseData struct {
+		Status types.Status                   `json:"status"`
+		Data   map[string]types.CryptoListing `json:"data"`
+	}
+	decodeErr := json.NewDecoder(resp.Body).Decode(&responseData)
+
+	if apiErr := cmcError(ctx, resp.StatusCode, responseData.Status); apiErr != nil {
+		return types.CryptoListing{}, apiErr
+	}
+	if decodeErr != nil {
+		return types.CryptoListing{}, errUpstreamBadResponse("coinmarketcap", decodeErr)
+	}
+
+	for _, coin := range responseData.Data {
+		return coin, nil
+	}
+	return types.CryptoListing{}, errNotFound("No coin with " + field + " " + strconv.Quote(value) + ".")
+}
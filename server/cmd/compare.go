@@ -0,0 +1,187 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	templates "server/html"
+	"server/types"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxCompare bounds the coins of one comparison, the ratio table grows
+// with the square of it.
+const maxCompare = 10
+
+// compareMetrics are the compared fields, in display order. A value of
+// ok == false means the coin has none.
+var compareMetrics = []struct {
+	name  string
+	value func(l types.CryptoListing) (v float64, ok bool)
+}{
+	{"price", func(l types.CryptoListing) (float64, bool) { return l.Quote["USD"].Price, true }},
+	{"market_cap", func(l types.CryptoListing) (float64, bool) { return l.Quote["USD"].MarketCap, true }},
+	{"fully_diluted_market_cap", func(l types.CryptoListing) (float64, bool) { return l.Quote["USD"].FullyDilutedMarketCap, true }},
+	{"volume_24h", func(l types.CryptoListing) (float64, bool) { return l.Quote["USD"].Volume24h, true }},
+	{"volume_change_24h", func(l types.CryptoListing) (float64, bool) { return l.Quote["USD"].VolumeChange24h, true }},
+	{"percent_change_1h", func(l types.CryptoListing) (float64, bool) { return l.Quote["USD"].PercentChange1h, true }},
+	{"percent_change_24h", func(l types.CryptoListing) (float64, bool) { return l.Quote["USD"].PercentChange24h, true }},
+	{"percent_change_7d", func(l types.CryptoListing) (float64, bool) { return l.Quote["USD"].PercentChange7d, true }},
+	{"market_cap_dominance", func(l types.CryptoListing) (float64, bool) { return l.Quote["USD"].MarketCapDominance, true }},
+	{"circulating_supply", func(l types.CryptoListing) (float64, bool) { return l.CirculatingSupply, true }},
+	{"total_supply", func(l types.CryptoListing) (float64, bool) { return l.TotalSupply, true }},
+	{"max_supply", func(l types.CryptoListing) (float64, bool) { return l.MaxSupply, l.MaxSupply > 0 }},
+	{"num_market_pairs", func(l types.CryptoListing) (float64, bool) { return float64(l.NumMarketPairs), true }},
+}
+
+// compareHandler serves /api/compare?coins=BTC,ETH,SOL. Coins are resolved
+// like the coin endpoint resolves them, against the cached listings.
+func compareHandler(w http.ResponseWriter, r *http.Request) {
+	p, format, err := clientParams(r)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	refs := p.list("coins")
+	switch {
+	case len(refs) < 2:
+		p.fail("coins", "needs at least 2 coins")
+	case len(refs) > maxCompare:
+		p.fail("coins", "at most %d coins", maxCompare)
+	}
+	for _, ref := range refs {
+		if !validCoinRef(ref) {
+			p.fail("coins", "%q is not a CoinMarketCap ID, slug or symbol", ref)
+		}
+	}
+	if err := p.err(); err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	if !allowRequest(w, r, format) {
+		return
+	}
+
+	listings, err := latestListings.get(r.Context(), upstreamClient)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	var coins []types.CryptoListing
+	seen := make(map[int]bool)
+	for _, ref := range refs {
+		l, ok := findListing(listings, ref)
+		if !ok {
+			p.fail("coins", "%q is not among the top %d coins by market cap", ref, cfg.QuoteListingLimit)
+			continue
+		}
+		if seen[l.ID] {
+			p.fail("coins", "%q is listed twice", ref)
+			continue
+		}
+		seen[l.ID] = true
+		coins = append(coins, l)
+	}
+	if err := p.err(); err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	comparison := compareListings(coins)
+	comparison.APIKey = p.str("api-key")
+
+	if format == "json" {
+		writeJSON(w, http.StatusOK, comparison)
+		return
+	}
+	renderHTML(w, r, http.StatusOK, templates.Compare, comparison)
+}
+
+// compareListings builds the metric table and the ratio of every later
+// coin to every earlier one, so coins=BTC,ETH gives ETH/BTC.
+func compareListings(coins []types.CryptoListing) types.Comparison {
+	c := types.Comparison{
+		Coins:   make([]types.ComparedCoin, len(coins)),
+		Metrics: make([]types.Metric, 0, len(compareMetrics)),
+		Ratios:  []types.Ratio{},
+	}
+	for i, l := range coins {
+		c.Coins[i] = types.ComparedCoin{ID: l.ID, Name: l.Name, Symbol: l.Symbol, Slug: l.Slug}
+	}
+
+	for _, m := range compareMetrics {
+		values := make([]types.MetricValue, len(coins))
+		for i, l := range coins {
+			if v, ok := m.value(l); ok {
+				values[i].Value = &v
+			}
+		}
+		rankValues(values)
+		c.Metrics = append(c.Metrics, types.Metric{Name: m.name, Values: values})
+	}
+
+	for i := range coins {
+		for j := i + 1; j < len(coins); j++ {
+			base, quote := coins[j].Quote["USD"], coins[i].Quote["USD"]
+			c.Ratios = append(c.Ratios, types.Ratio{
+				Base:      coins[j].Symbol,
+				Quote:     coins[i].Symbol,
+				Price:     ratio(base.Price, quote.Price),
+				MarketCap: ratio(base.MarketCap, quote.MarketCap),
+				Volume24h: ratio(base.Volume24h, quote.Volume24h),
+			})
+		}
+	}
+	return c
+}
+
+// rankValues sets competition ranks, highest first; equal values share a
+// rank and missing values stay unranked.
+func rankValues(values []types.MetricValue) {
+	var order []int
+	for i, v := range values {
+		if v.Value != nil {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return *values[order[a]].Value > *values[order[b]].Value
+	})
+
+	for pos, i := range order {
+		if pos > 0 && *values[i].Value == *values[order[pos-1]].Value {
+			values[i].Rank = values[order[pos-1]].Rank
+		} else {
+			values[i].Rank = pos + 1
+		}
+	}
+}
+
+func ratio(a, b float64) *float64 {
+	if b == 0 {
+		return nil
+	}
+	r := a / b
+	return &r
+}
+
+// metricLabel turns a metric name into a table heading.
+func metricLabel(name string) string {
+	label := strings.ReplaceAll(name, "_", " ")
+	return strings.ToUpper(label[:1]) + label[1:]
+}
+
+// shortNumber formats large values with a B or M suffix for the tables.
+func shortNumber(v float64) string {
+	switch a := math.Abs(v); {
+	case a >= 1e9:
+		return strconv.FormatFloat(v/1e9, 'f', 2, 64) + "B"
+	case a >= 1e6:
+		return strconv.FormatFloat(v/1e6, 'f', 2, 64) + "M"
+	}
+	return strconv.FormatFloat(v, 'g', 6, 64)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the server settings. Every field can be overridden with an
+// environment variable, see loadConfig for the names and defaults.
+type Config struct {
+	Addr              string
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	ShutdownTimeout   time.Duration
+	// ShutdownDrainDelay is how long /readyz reports not ready before the
+	// server stops accepting connections, so load balancers stop sending
+	// traffic first
+	ShutdownDrainDelay time.Duration
+
+	LogLevel  string
+	LogFormat string
+
+	// APIKeys are the keys our own clients send in the api-key parameter,
+	// one per client so each gets its own portfolio, transactions and
+	// indices; the other two authenticate us against the upstream providers
+	APIKeys         []string
+	CMCAPIKey       string
+	CoinGeckoAPIKey string
+
+	CoinIndexTTL time.Duration
+
+	// ListingsCacheTTL is how long the shared market listing, used to value
+	// portfolios, stays fresh; QuoteListingLimit is how many coins, by market
+	// cap, it covers
+	ListingsCacheTTL  time.Duration
+	QuoteListingLimit int
+
+	// MaxListingLimit caps the limit parameter; limits above
+	// CMCMaxPageSize are served by several upstream calls
+	MaxListingLimit int
+	MaxListingStart int
+	CMCMaxPageSize  int
+
+	// DataDir is where persistent state is kept
+	DataDir string
+
+	// SnapshotInterval is how often the top SnapshotLimit listings are
+	// stored, 0 disables snapshots; snapshots and index NAV points older
+	// than SnapshotRetention are deleted, 0 keeps them forever, so the NAV
+	// history then grows by one point per snapshot; the peg history has a
+	// fixed cap on top, see maxPegHistory. SnapshotCurrencies are the quote
+	// currencies stored, USD always among them
+	SnapshotInterval   time.Duration
+	SnapshotLimit      int
+	SnapshotRetention  time.Duration
+	SnapshotCurrencies []string
+
+	// RiskFreeRate is the yearly return, as a fraction, that Sharpe and
+	// Sortino ratios are measured against unless a request overrides it
+	RiskFreeRate float64
+
+	// DepegThreshold is the deviation from the peg, in percent, at which a
+	// stablecoin is reported as depegged and an alert fires; alerts are
+	// also posted to DepegWebhookURL when it is set. DepegPegs maps
+	// symbols to the currency they are pegged to, for coins CoinMarketCap
+	// has no currency stablecoin tag for. DepegIgnore are symbols not to
+	// check at all
+	DepegThreshold  float64
+	DepegWebhookURL string
+	DepegPegs       map[string]string
+	DepegIgnore     []string
+
+	ReadinessTimeout  time.Duration
+	ReadinessCacheTTL time.Duration
+}
+
+// cfg is the configuration the server was started with.
+var cfg Config
+
+func loadConfig() (Config, error) {
+	var cfg Config
+	var err error
+
+	cfg.Addr = envString("SERVER_ADDR", ":8080")
+
+	if cfg.ReadTimeout, err = envDuration("SERVER_READ_TIMEOUT", 10*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.ReadHeaderTimeout, err = envDuration("SERVER_READ_HEADER_TIMEOUT", 5*time.Second); err != nil {
+		return cfg, err
+	}
+	// upstream calls are made while the response is being written, so the
+	// write timeout has to cover them as well
+	if cfg.WriteTimeout, err = envDuration("SERVER_WRITE_TIMEOUT", 30*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.IdleTimeout, err = envDuration("SERVER_IDLE_TIMEOUT", 60*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxHeaderBytes, err = envInt("SERVER_MAX_HEADER_BYTES", 1<<20); err != nil {
+		return cfg, err
+	}
+	if cfg.ShutdownTimeout, err = envDuration("SERVER_SHUTDOWN_TIMEOUT", 15*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.ShutdownDrainDelay, err = envDuration("SERVER_SHUTDOWN_DRAIN_DELAY", 5*time.Second); err != nil {
+		return cfg, err
+	}
+
+	cfg.LogLevel = envString("LOG_LEVEL", "info")
+	cfg.LogFormat = envString("LOG_FORMAT", "text")
+
+	// API_KEY is the single key of older setups
+	for _, key := range strings.Split(envString("API_KEYS", envString("API_KEY", "123")), ",") {
+		if key = strings.TrimSpace(key); key != "" && !contains(cfg.APIKeys, key) {
+			cfg.APIKeys = append(cfg.APIKeys, key)
+		}
+	}
+	if len(cfg.APIKeys) == 0 {
+		return cfg, fmt.Errorf("invalid API_KEYS: at least one key is required")
+	}
+	cfg.CMCAPIKey = envString("CMC_API_KEY", "713a6b7d-6e93-4d59-88ea-038f57de2ae6")
+	cfg.CoinGeckoAPIKey = envString("COINGECKO_API_KEY", "CG-x46kYuMHifPvVb46Qxj8WnRs")
+
+	if cfg.CoinIndexTTL, err = envDuration("COIN_INDEX_TTL", time.Hour); err != nil {
+		return cfg, err
+	}
+
+	if cfg.ListingsCacheTTL, err = envDuration("LISTINGS_CACHE_TTL", time.Minute); err != nil {
+		return cfg, err
+	}
+	if cfg.QuoteListingLimit, err = envInt("QUOTE_LISTING_LIMIT", 5000); err != nil {
+		return cfg, err
+	}
+	if cfg.QuoteListingLimit == 0 {
+		return cfg, fmt.Errorf("invalid QUOTE_LISTING_LIMIT: must be greater than 0")
+	}
+
+	if cfg.MaxListingLimit, err = envInt("MAX_LISTING_LIMIT", 10000); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxListingStart, err = envInt("MAX_LISTING_START", 50000); err != nil {
+		return cfg, err
+	}
+	if cfg.CMCMaxPageSize, err = envInt("CMC_MAX_PAGE_SIZE", 5000); err != nil {
+		return cfg, err
+	}
+	if cfg.CMCMaxPageSize == 0 {
+		return cfg, fmt.Errorf("invalid CMC_MAX_PAGE_SIZE: must be greater than 0")
+	}
+
+	cfg.DataDir = envString("DATA_DIR", "data")
+
+	if cfg.SnapshotInterval, err = envDuration("SNAPSHOT_INTERVAL", 15*time.Minute); err != nil {
+		return cfg, err
+	}
+	if cfg.SnapshotLimit, err = envInt("SNAPSHOT_LIMIT", 500); err != nil {
+		return cfg, err
+	}
+	if cfg.SnapshotLimit == 0 {
+		return cfg, fmt.Errorf("invalid SNAPSHOT_LIMIT: must be greater than 0")
+	}
+	if cfg.SnapshotRetention, err = envDuration("SNAPSHOT_RETENTION", 30*24*time.Hour); err != nil {
+		return cfg, err
+	}
+	// the listings endpoint and the snapshot diff work on USD quotes
+	cfg.SnapshotCurrencies = []string{"USD"}
+	for _, c := range strings.Split(envString("SNAPSHOT_CURRENCIES", "USD"), ",") {
+		c = strings.ToUpper(strings.TrimSpace(c))
+		if c == "" || c == "USD" {
+			continue
+		}
+		if !isCurrencyCode(c) {
+			return cfg, fmt.Errorf("invalid SNAPSHOT_CURRENCIES: %q is not a currency code", c)
+		}
+		cfg.SnapshotCurrencies = append(cfg.SnapshotCurrencies, c)
+	}
+
+	if cfg.RiskFreeRate, err = envFloat("RISK_FREE_RATE", 0); err != nil {
+		return cfg, err
+	}
+
+	if cfg.DepegThreshold, err = envFloat("DEPEG_THRESHOLD", 0.5); err != nil {
+		return cfg, err
+	}
+	if cfg.DepegThreshold == 0 {
+		return cfg, fmt.Errorf("invalid DEPEG_THRESHOLD: must be greater than 0")
+	}
+	cfg.DepegWebhookURL = envString("DEPEG_WEBHOOK_URL", "")
+	if cfg.DepegWebhookURL != "" {
+		if u, err := url.Parse(cfg.DepegWebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return cfg, fmt.Errorf("invalid DEPEG_WEBHOOK_URL %q: must be an http or https URL", cfg.DepegWebhookURL)
+		}
+	}
+	cfg.DepegPegs = make(map[string]string)
+	for _, pair := range strings.Split(envString("DEPEG_PEGS", ""), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		symbol, currency, ok := strings.Cut(pair, "=")
+		symbol, currency = strings.ToUpper(strings.TrimSpace(symbol)), strings.ToUpper(strings.TrimSpace(currency))
+		if !ok || symbol == "" || !isCurrencyCode(currency) {
+			return cfg, fmt.Errorf("invalid DEPEG_PEGS: %q must be SYMBOL=CURRENCY, such as EURC=EUR", pair)
+		}
+		cfg.DepegPegs[symbol] = currency
+	}
+	for _, symbol := range strings.Split(envString("DEPEG_IGNORE", ""), ",") {
+		if symbol = strings.ToUpper(strings.TrimSpace(symbol)); symbol != "" {
+			cfg.DepegIgnore = append(cfg.DepegIgnore, symbol)
+		}
+	}
+
+	if cfg.ReadinessTimeout, err = envDuration("READINESS_TIMEOUT", 3*time.Second); err != nil {
+		return cfg, err
+	}
+	if cfg.ReadinessCacheTTL, err = envDuration("READINESS_CACHE_TTL", 10*time.Second); err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+func envString(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+func envDuration(key string, def time.Duration) (time.Duration, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a non-negative duration such as 10s", key, v)
+	}
+	return d, nil
+}
+
+func envInt(key string, def int) (int, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a non-negative integer", key, v)
+	}
+	return n, nil
+}
+
+func envFloat(key string, def float64) (float64, error) {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) || f < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a non-negative number", key, v)
+	}
+	return f, nil
+}
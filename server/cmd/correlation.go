@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	templates "server/html"
+	"server/types"
+	"sort"
+	"time"
+)
+
+// minCorrelationReturns is the fewest shared returns a correlation is
+// computed from.
+const minCorrelationReturns = 3
+
+// correlationHandler serves /api/correlation?coins=&window=. Returns are
+// taken between stored candles only, a pause in the snapshots leaves a hole
+// instead of a made-up flat return, and every pair of coins is compared
+// over the times both have a return.
+func correlationHandler(w http.ResponseWriter, r *http.Request) {
+	p, format, err := clientParams(r)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	refs := p.list("coins")
+	switch {
+	case len(refs) < 2:
+		p.fail("coins", "needs at least 2 coins")
+	case len(refs) > maxCompare:
+		p.fail("coins", "at most %d coins", maxCompare)
+	}
+	for _, ref := range refs {
+		if !validCoinRef(ref) {
+			p.fail("coins", "%q is not a CoinMarketCap ID, slug or symbol", ref)
+		}
+	}
+	interval := p.enum("interval", "1d", candleIntervalNames(), false)
+	currency := snapshotCurrency(p)
+	window := p.integer("window", defaultRiskWindow, minCorrelationReturns, maxCandles-1, false)
+	to := p.timestamp("to")
+	if err := p.err(); err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	if !allowRequest(w, r, format) {
+		return
+	}
+
+	snap, err := latestSnapshot()
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	var coins []types.CryptoListing
+	seen := make(map[int]bool)
+	for _, ref := range refs {
+		l, ok := findListing(snap.Listings, ref)
+		if !ok {
+			p.fail("coins", "%q is not in the newest snapshot", ref)
+			continue
+		}
+		if seen[l.ID] {
+			p.fail("coins", "%q is listed twice", ref)
+			continue
+		}
+		seen[l.ID] = true
+		coins = append(coins, l)
+	}
+	if err := p.err(); err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	size := candleIntervalSize(interval)
+	if to.IsZero() {
+		to = time.Now().UTC()
+	}
+	// the candle containing to is included, window returns need one
+	// candle more
+	to = to.Truncate(size).Add(size)
+	from := to.Add(-time.Duration(window+1) * size)
+
+	m := types.CorrelationMatrix{
+		Currency:     currency,
+		Interval:     interval,
+		From:         from,
+		To:           to,
+		Window:       window,
+		Coins:        make([]types.CorrelationCoin, len(coins)),
+		Pearson:      make([][]*float64, len(coins)),
+		Spearman:     make([][]*float64, len(coins)),
+		Observations: make([][]int, len(coins)),
+		Caller:       types.Caller{APIKey: p.str("api-key")},
+	}
+
+	returns := make([]map[time.Time]float64, len(coins))
+	for i, c := range coins {
+		found, _, _ := candles.window(interval, currency, c.ID, from, to)
+		var dropped int
+		returns[i], dropped = candleReturns(found, size)
+		m.Coins[i] = types.CorrelationCoin{
+			ID:      c.ID,
+			Name:    c.Name,
+			Symbol:  c.Symbol,
+			Slug:    c.Slug,
+			Returns: len(returns[i]),
+			Missing: dropped,
+		}
+	}
+
+	for i := range coins {
+		m.Pearson[i] = make([]*float64, len(coins))
+		m.Spearman[i] = make([]*float64, len(coins))
+		m.Observations[i] = make([]int, len(coins))
+	}
+	for i := range coins {
+		for j := i; j < len(coins); j++ {
+			x, y := alignReturns(returns[i], returns[j])
+			m.Observations[i][j], m.Observations[j][i] = len(x), len(x)
+			if len(x) < minCorrelationReturns {
+				continue
+			}
+			m.Pearson[i][j] = finite(CalculatePearson(x, y))
+			m.Spearman[i][j] = finite(CalculateSpearman(x, y))
+			m.Pearson[j][i], m.Spearman[j][i] = m.Pearson[i][j], m.Spearman[i][j]
+		}
+	}
+
+	if format == "json" {
+		writeJSON(w, http.StatusOK, m)
+		return
+	}
+	renderHTML(w, r, http.StatusOK, templates.Correlation, m)
+}
+
+// candleReturns is the return of every candle since the stored candle
+// before it, by candle time. Candles finer than the snapshots are apart by
+// a few intervals; after a pause of sampleGap or more there is no return,
+// dropped counts those.
+func candleReturns(series []types.Candle, size time.Duration) (returns map[time.Time]float64, dropped int) {
+	returns = make(map[time.Time]float64, len(series))
+	for i := 1; i < len(series); i++ {
+		if step := series[i].Time.Sub(series[i-1].Time); step != size && step >= sampleGap() {
+			dropped++
+			continue
+		}
+		returns[series[i].Time] = series[i].Close/series[i-1].Close - 1
+	}
+	return returns, dropped
+}
+
+// alignReturns pairs the returns of two coins at the times both have one,
+// oldest first.
+func alignReturns(a, b map[time.Time]float64) (x, y []float64) {
+	times := make([]time.Time, 0, len(a))
+	for t := range a {
+		if _, ok := b[t]; ok {
+			times = append(times, t)
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	for _, t := range times {
+		x = append(x, a[t])
+		y = append(y, b[t])
+	}
+	return x, y
+}
+
+// heatClass buckets a correlation into one of the heat-m4 to heat-4 classes
+// of correlation.html, strongly negative to strongly positive.
+func heatClass(v *float64) string {
+	if v == nil {
+		return "heat-none"
+	}
+	bucket := int(math.Round(*v * 4))
+	if bucket < 0 {
+		return fmt.Sprintf("heat-m%d", -bucket)
+	}
+	return fmt.Sprintf("heat-%d", bucket)
+}
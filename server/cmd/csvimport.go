@@ -0,0 +1,417 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"server/types"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxImportBytes bounds an uploaded CSV file.
+const maxImportBytes = 5 << 20
+
+var transactionTypes = []string{"buy", "sell", "transfer_in", "transfer_out"}
+
+// skipRow is returned by a layout for a row that is valid but describes
+// something the ledger does not track.
+type skipRow string
+
+func (s skipRow) Error() string { return string(s) }
+
+// csvLayout is one supported export format. It is recognised by its header
+// row, which has to contain every required column.
+type csvLayout struct {
+	name     string
+	required []string
+	parse    func(row csvRow, listings []types.CryptoListing) (types.Transaction, error)
+}
+
+var csvLayouts = []csvLayout{
+	{
+		name:     "generic",
+		required: []string{"date", "type", "coin", "quantity", "price"},
+		parse:    parseGenericRow,
+	},
+	{
+		name: "coinbase",
+		required: []string{"timestamp", "transaction type", "asset", "quantity transacted",
+			"spot price currency", "spot price at transaction"},
+		parse: parseCoinbaseRow,
+	},
+	{
+		name:     "binance",
+		required: []string{"date(utc)", "pair", "side", "price", "executed", "amount", "fee"},
+		parse:    parseBinanceRow,
+	},
+}
+
+func layoutNames() []string {
+	names := []string{"auto"}
+	for _, l := range csvLayouts {
+		names = append(names, l.name)
+	}
+	return names
+}
+
+// csvRow gives access to a record by lowercased header name.
+type csvRow struct {
+	columns map[string]int
+	record  []string
+}
+
+func (r csvRow) get(name string) string {
+	i, ok := r.columns[name]
+	if !ok || i >= len(r.record) {
+		return ""
+	}
+	return strings.TrimSpace(r.record[i])
+}
+
+// importCSV parses data in the given layout, or in the first layout whose
+// header it finds when layout is auto. Lines before the header are
+// ignored, exchanges like to put a title there. Malformed rows fail the
+// import with one fieldError per line; rows the ledger cannot represent are
+// skipped and reported in the result.
+func importCSV(data io.Reader, layout string, listings []types.CryptoListing) ([]types.Transaction, types.ImportResult, error) {
+	result := types.ImportResult{Skipped: []types.SkippedRow{}}
+
+	cr := csv.NewReader(data)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	var format *csvLayout
+	var columns map[string]int
+	for format == nil {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil, result, csvError("no header row of a known layout (%s)", strings.Join(layoutNames()[1:], ", "))
+		}
+		if err != nil {
+			return nil, result, csvError("%v", err)
+		}
+		format, columns = detectLayout(record, layout)
+	}
+	result.Layout = format.name
+
+	var txs []types.Transaction
+	var errs []fieldError
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		// a record that failed to parse has no field positions, the line
+		// is only in the error
+		var perr *csv.ParseError
+		if errors.As(err, &perr) {
+			errs = append(errs, fieldError{Field: fmt.Sprintf("csv line %d", perr.Line), Message: perr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fieldError{Field: "csv", Message: err.Error()})
+			break
+		}
+		line, _ := cr.FieldPos(0)
+		if isBlankRecord(record) {
+			continue
+		}
+
+		tx, err := format.parse(csvRow{columns: columns, record: record}, listings)
+		var skip skipRow
+		switch {
+		case errors.As(err, &skip):
+			result.Skipped = append(result.Skipped, types.SkippedRow{Line: line, Reason: string(skip)})
+		case err != nil:
+			errs = append(errs, fieldError{Field: fmt.Sprintf("csv line %d", line), Message: err.Error()})
+		default:
+			tx.Source = format.name
+			txs = append(txs, tx)
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, result, &validationError{Fields: errs}
+	}
+	return txs, result, nil
+}
+
+func csvError(format string, args ...any) error {
+	return &validationError{Fields: []fieldError{{Field: "csv", Message: fmt.Sprintf(format, args...)}}}
+}
+
+func detectLayout(record []string, layout string) (*csvLayout, map[string]int) {
+	columns := make(map[string]int, len(record))
+	for i, name := range record {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for i := range csvLayouts {
+		l := &csvLayouts[i]
+		if layout != "auto" && layout != l.name {
+			continue
+		}
+		found := true
+		for _, name := range l.required {
+			if _, ok := columns[name]; !ok {
+				found = false
+				break
+			}
+		}
+		if found {
+			return l, columns
+		}
+	}
+	return nil, nil
+}
+
+func isBlankRecord(record []string) bool {
+	for _, f := range record {
+		if strings.TrimSpace(f) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// parseGenericRow reads our own layout: date, type, coin, quantity, price
+// and an optional fee, all amounts in USD.
+func parseGenericRow(row csvRow, listings []types.CryptoListing) (types.Transaction, error) {
+	t, err := parseTime(row.get("date"))
+	if err != nil {
+		return types.Transaction{}, err
+	}
+
+	typ := strings.ToLower(row.get("type"))
+	if !contains(transactionTypes, typ) {
+		return types.Transaction{}, fmt.Errorf("type must be one of: %s", strings.Join(transactionTypes, ", "))
+	}
+
+	quantity, err := parseAmount("quantity", row.get("quantity"))
+	if err != nil {
+		return types.Transaction{}, err
+	}
+	price, err := parseAmount("price", row.get("price"))
+	if err != nil {
+		return types.Transaction{}, err
+	}
+	fee, err := parseAmount("fee", row.get("fee"))
+	if err != nil {
+		return types.Transaction{}, err
+	}
+
+	return newTransaction(t, typ, row.get("coin"), quantity, price, fee, listings)
+}
+
+// parseCoinbaseRow reads the Coinbase transaction history export. Income
+// such as staking rewards is a transfer in at the spot price, which becomes
+// its cost basis. Conversions move value between two coins in one row and
+// are skipped.
+func parseCoinbaseRow(row csvRow, listings []types.CryptoListing) (types.Transaction, error) {
+	var typ string
+	kind := strings.ToLower(row.get("transaction type"))
+	switch {
+	case strings.HasSuffix(kind, "buy"):
+		typ = "buy"
+	case strings.HasSuffix(kind, "sell"):
+		typ = "sell"
+	case kind == "send", kind == "withdrawal":
+		typ = "transfer_out"
+	case kind == "receive", kind == "deposit", strings.Contains(kind, "income"), strings.Contains(kind, "reward"):
+		typ = "transfer_in"
+	default:
+		return types.Transaction{}, skipRow(fmt.Sprintf("%q transactions are not supported", row.get("transaction type")))
+	}
+
+	if currency := strings.ToUpper(row.get("spot price currency")); currency != "USD" {
+		return types.Transaction{}, skipRow("prices in " + currency + " are not supported, export the report in USD")
+	}
+
+	t, err := parseTime(row.get("timestamp"))
+	if err != nil {
+		return types.Transaction{}, err
+	}
+	// newer exports sign the quantity of outgoing rows
+	quantity, err := parseAmount("quantity transacted", strings.TrimPrefix(row.get("quantity transacted"), "-"))
+	if err != nil {
+		return types.Transaction{}, err
+	}
+	price, err := parseAmount("spot price at transaction", row.get("spot price at transaction"))
+	if err != nil {
+		return types.Transaction{}, err
+	}
+	feeColumn := "fees and/or spread"
+	if _, ok := row.columns[feeColumn]; !ok {
+		feeColumn = "fees"
+	}
+	fee, err := parseAmount(feeColumn, row.get(feeColumn))
+	if err != nil {
+		return types.Transaction{}, err
+	}
+
+	return newTransaction(t, typ, row.get("asset"), quantity, price, fee, listings)
+}
+
+// usdQuotes are the quote assets a Binance pair may have to be imported,
+// longest first so BTCFDUSD is not read as BTCFD/USD.
+var usdQuotes = []string{"FDUSD", "BUSD", "TUSD", "USDT", "USDC", "DAI", "USD"}
+
+// parseBinanceRow reads the Binance spot trade history export. Amounts
+// carry their asset as a suffix ("0.5BTC"). A fee paid in the base asset
+// is taken out of the coins bought; one paid in a third asset, usually
+// BNB, is converted at that asset's current price, the export has no
+// historical one.
+func parseBinanceRow(row csvRow, listings []types.CryptoListing) (types.Transaction, error) {
+	pair := strings.ToUpper(row.get("pair"))
+	var base, quote string
+	for _, q := range usdQuotes {
+		if strings.HasSuffix(pair, q) && len(pair) > len(q) {
+			base, quote = strings.TrimSuffix(pair, q), q
+			break
+		}
+	}
+	if base == "" {
+		return types.Transaction{}, skipRow("pair " + pair + " is not quoted in USD or a USD stablecoin")
+	}
+
+	typ := strings.ToLower(row.get("side"))
+	if typ != "buy" && typ != "sell" {
+		return types.Transaction{}, fmt.Errorf("side must be BUY or SELL")
+	}
+
+	t, err := parseTime(row.get("date(utc)"))
+	if err != nil {
+		return types.Transaction{}, err
+	}
+	price, err := parseAmount("price", row.get("price"))
+	if err != nil {
+		return types.Transaction{}, err
+	}
+	quantity, _, err := parseAssetAmount("executed", row.get("executed"), base)
+	if err != nil {
+		return types.Transaction{}, err
+	}
+	fee, feeAsset, err := parseAssetAmount("fee", row.get("fee"), base, quote)
+	if err != nil {
+		return types.Transaction{}, err
+	}
+
+	switch {
+	case feeAsset == base:
+		// a buy delivers the executed amount less the fee
+		if typ == "buy" {
+			if fee >= quantity {
+				return types.Transaction{}, fmt.Errorf("fee must be less than the executed amount")
+			}
+			quantity -= fee
+		}
+		fee *= price
+	case feeAsset == quote, feeAsset == "":
+	default:
+		l, ok := findSymbol(listings, feeAsset)
+		if !ok {
+			return types.Transaction{}, fmt.Errorf("fee asset %s has no current price", feeAsset)
+		}
+		fee *= l.Quote["USD"].Price
+	}
+
+	return newTransaction(t, typ, base, quantity, price, fee, listings)
+}
+
+// newTransaction resolves symbol against the listings so the transaction
+// can be valued later. Coins outside the listings are kept by symbol only.
+func newTransaction(t time.Time, typ, symbol string, quantity, price, fee float64, listings []types.CryptoListing) (types.Transaction, error) {
+	symbol = strings.ToUpper(symbol)
+	if symbol == "" {
+		return types.Transaction{}, fmt.Errorf("coin is required")
+	}
+	if quantity == 0 {
+		return types.Transaction{}, fmt.Errorf("quantity must be greater than 0")
+	}
+
+	tx := types.Transaction{Time: t, Type: typ, Symbol: symbol, Quantity: quantity, Price: price, Fee: fee}
+	if l, ok := findSymbol(listings, symbol); ok {
+		tx.CoinID = l.ID
+		tx.Name = l.Name
+	}
+	return tx, nil
+}
+
+// findSymbol is findListing restricted to symbols; CSV exports never use
+// slugs or IDs.
+func findSymbol(listings []types.CryptoListing, symbol string) (types.CryptoListing, bool) {
+	for _, l := range listings {
+		if strings.EqualFold(l.Symbol, symbol) {
+			return l, true
+		}
+	}
+	return types.CryptoListing{}, false
+}
+
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05 MST",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	time.DateOnly,
+}
+
+// parseTime accepts the timestamp formats of the supported layouts. Times
+// without a zone are UTC.
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			t = t.UTC()
+			if t.After(time.Now().UTC()) {
+				return time.Time{}, fmt.Errorf("date %q is in the future", s)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("date %q is not a date such as 2024-01-31 or 2024-01-31T15:04:05Z", s)
+}
+
+var amountCleaner = strings.NewReplacer("$", "", ",", "", " ", "")
+
+// parseAmount reads a non-negative number, allowing a dollar sign and
+// thousands separators. An empty value is zero.
+func parseAmount(name, s string) (float64, error) {
+	s = amountCleaner.Replace(s)
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0, fmt.Errorf("%s %q is not a number", name, s)
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("%s must not be negative", name)
+	}
+	return n, nil
+}
+
+// parseAssetAmount splits a Binance amount such as "0.5BTC" into the
+// number and the asset. The known assets are tried first, because asset
+// names may start with a digit (1INCH).
+func parseAssetAmount(name, s string, known ...string) (float64, string, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	for _, asset := range known {
+		if strings.HasSuffix(s, asset) {
+			n, err := parseAmount(name, strings.TrimSuffix(s, asset))
+			return n, asset, err
+		}
+	}
+
+	i := strings.IndexFunc(s, func(r rune) bool { return r >= 'A' && r <= 'Z' })
+	if i < 0 {
+		n, err := parseAmount(name, s)
+		return n, "", err
+	}
+	n, err := parseAmount(name, s[:i])
+	return n, s[i:], err
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImportCSVMalformedQuote(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+		want []fieldError
+	}{
+		{
+			name: "bare quote in the first field",
+			csv: "date,type,coin,quantity,price\n" +
+				"2024-01-01,buy,BTC,1,100\n" +
+				"ab\"c,buy,BTC,1,100\n" +
+				"2024-01-02,sell,BTC,1,110\n",
+			want: []fieldError{{Field: "csv line 3", Message: `bare " in non-quoted-field`}},
+		},
+		{
+			name: "bare quote in a later field",
+			csv: "date,type,coin,quantity,price\n" +
+				"2024-01-01,b\"uy,BTC,1,100\n",
+			want: []fieldError{{Field: "csv line 2", Message: `bare " in non-quoted-field`}},
+		},
+		{
+			name: "unterminated quoted field",
+			csv: "date,type,coin,quantity,price\n" +
+				"\"2024-01-01,buy,BTC,1,100\n",
+			want: []fieldError{{Field: "csv line 2", Message: `extraneous or missing " in quoted-field`}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := importCSV(strings.NewReader(tt.csv), "auto", nil)
+			var verr *validationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("importCSV() error = %v, want a validationError", err)
+			}
+			if !reflect.DeepEqual(verr.Fields, tt.want) {
+				t.Errorf("importCSV() fields = %+v, want %+v", verr.Fields, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportCSVBinanceFees(t *testing.T) {
+	const header = "Date(UTC),Pair,Side,Price,Executed,Amount,Fee\n"
+	tests := []struct {
+		name         string
+		row          string
+		wantQuantity float64
+		wantFee      float64
+	}{
+		{
+			name:         "buy with the fee in the base asset",
+			row:          "2024-01-01 10:00:00,BTCUSDT,BUY,40000,0.5BTC,20000USDT,0.001BTC\n",
+			wantQuantity: 0.499,
+			wantFee:      40,
+		},
+		{
+			name:         "buy with the fee in the quote asset",
+			row:          "2024-01-01 10:00:00,BTCUSDT,BUY,40000,0.5BTC,20000USDT,20USDT\n",
+			wantQuantity: 0.5,
+			wantFee:      20,
+		},
+		{
+			name:         "sell with the fee in the base asset",
+			row:          "2024-01-01 10:00:00,BTCUSDT,SELL,40000,0.5BTC,20000USDT,0.001BTC\n",
+			wantQuantity: 0.5,
+			wantFee:      40,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txs, _, err := importCSV(strings.NewReader(header+tt.row), "binance", nil)
+			if err != nil {
+				t.Fatalf("importCSV() error = %v", err)
+			}
+			if len(txs) != 1 {
+				t.Fatalf("importCSV() imported %d transactions, want 1", len(txs))
+			}
+			if got := txs[0].Quantity; math.Abs(got-tt.wantQuantity) > 1e-12 {
+				t.Errorf("quantity = %v, want %v", got, tt.wantQuantity)
+			}
+			if got := txs[0].Fee; math.Abs(got-tt.wantFee) > 1e-9 {
+				t.Errorf("fee = %v, want %v", got, tt.wantFee)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	templates "server/html"
+	"server/types"
+	"sort"
+	"time"
+)
+
+// maxMovers bounds each biggest movers list of a snapshot diff.
+const maxMovers = 50
+
+// diffHandler serves /api/diff?from=&to=, the changes in the top coins
+// between the stored snapshots nearest to from and to. Without to the
+// newest snapshot is used.
+func diffHandler(w http.ResponseWriter, r *http.Request) {
+	p, format, err := clientParams(r)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	if p.str("from") == "" {
+		p.fail("from", "is required")
+	}
+	from := p.timestamp("from")
+	to := p.timestamp("to")
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		p.fail("from", "must be before to")
+	}
+	top := p.integer("top", min(100, cfg.SnapshotLimit), 1, cfg.SnapshotLimit, false)
+	movers := p.integer("movers", 5, 1, maxMovers, false)
+	if err := p.err(); err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	if !allowRequest(w, r, format) {
+		return
+	}
+
+	fromAt, ok := snapshots.nearest(from)
+	if !ok {
+		writeError(w, r, format, errNotFound("No listing snapshot is stored yet."))
+		return
+	}
+	var toAt time.Time
+	if to.IsZero() {
+		to = time.Now().UTC()
+		toAt, _ = snapshots.latest()
+	} else {
+		toAt, _ = snapshots.nearest(to)
+	}
+	if fromAt.Equal(toAt) {
+		p.fail("to", "resolves to the same snapshot as from, taken %s", fromAt.Format(time.RFC3339))
+		writeError(w, r, format, p.err())
+		return
+	}
+
+	before, err := snapshots.load(fromAt)
+	if err != nil {
+		writeError(w, r, format, errInternal(err))
+		return
+	}
+	after, err := snapshots.load(toAt)
+	if err != nil {
+		writeError(w, r, format, errInternal(err))
+		return
+	}
+
+	diff := diffSnapshots(before, after, top, movers)
+	diff.From = snapshotInfo(from, before.Time)
+	diff.To = snapshotInfo(to, after.Time)
+	diff.APIKey = p.str("api-key")
+
+	if format == "json" {
+		writeJSON(w, http.StatusOK, diff)
+		return
+	}
+	renderHTML(w, r, http.StatusOK, templates.Diff, diff)
+}
+
+// diffSnapshots compares the coins ranked top or better in from and to.
+// Each mover list holds at most movers coins.
+func diffSnapshots(from, to types.Snapshot, top, movers int) types.SnapshotDiff {
+	before, after := listingsByID(from.Listings), listingsByID(to.Listings)
+	inTop := func(l types.CryptoListing, ok bool) bool {
+		return ok && l.CMCRank > 0 && l.CMCRank <= top
+	}
+
+	d := types.SnapshotDiff{
+		Top:        top,
+		Entered:    []types.CoinDiff{},
+		Left:       []types.CoinDiff{},
+		TagChanges: []types.CoinDiff{},
+		Coins:      []types.CoinDiff{},
+	}
+
+	var both []types.CoinDiff
+	seen := make(map[int]bool)
+	for _, l := range append(append([]types.CryptoListing{}, to.Listings...), from.Listings...) {
+		if seen[l.ID] {
+			continue
+		}
+		b, inBefore := before[l.ID]
+		a, inAfter := after[l.ID]
+		topBefore, topAfter := inTop(b, inBefore), inTop(a, inAfter)
+		if !topBefore && !topAfter {
+			continue
+		}
+		seen[l.ID] = true
+
+		c := diffCoin(b, inBefore, a, inAfter)
+		d.Coins = append(d.Coins, c)
+		switch {
+		case topAfter && !topBefore:
+			d.Entered = append(d.Entered, c)
+		case topBefore && !topAfter:
+			d.Left = append(d.Left, c)
+		}
+		if inBefore && inAfter {
+			both = append(both, c)
+			if len(c.TagsAdded) > 0 || len(c.TagsRemoved) > 0 {
+				d.TagChanges = append(d.TagChanges, c)
+			}
+		}
+	}
+
+	sort.SliceStable(d.Coins, func(i, j int) bool { return byRank(d.Coins[i], d.Coins[j]) })
+	sort.SliceStable(d.Entered, func(i, j int) bool { return d.Entered[i].ToRank < d.Entered[j].ToRank })
+	sort.SliceStable(d.Left, func(i, j int) bool { return d.Left[i].FromRank < d.Left[j].FromRank })
+	sort.SliceStable(d.TagChanges, func(i, j int) bool { return byRank(d.TagChanges[i], d.TagChanges[j]) })
+
+	rank := func(c types.CoinDiff) float64 { return float64(c.RankChange) }
+	price := func(c types.CoinDiff) float64 { return c.PriceChangePercent }
+	marketCap := func(c types.CoinDiff) float64 { return c.MarketCapChangePercent }
+	d.RankGainers = biggestMovers(both, rank, 1, movers)
+	d.RankLosers = biggestMovers(both, rank, -1, movers)
+	d.PriceGainers = biggestMovers(both, price, 1, movers)
+	d.PriceLosers = biggestMovers(both, price, -1, movers)
+	d.MarketCapGainers = biggestMovers(both, marketCap, 1, movers)
+	d.MarketCapLosers = biggestMovers(both, marketCap, -1, movers)
+	return d
+}
+
+// byRank orders coins by their rank in the later snapshot, the ones that
+// left it by their earlier rank after them.
+func byRank(a, b types.CoinDiff) bool {
+	if (a.ToRank == 0) != (b.ToRank == 0) {
+		return a.ToRank != 0
+	}
+	if a.ToRank != b.ToRank {
+		return a.ToRank < b.ToRank
+	}
+	return a.FromRank < b.FromRank
+}
+
+func diffCoin(before types.CryptoListing, inBefore bool, after types.CryptoListing, inAfter bool) types.CoinDiff {
+	l := after
+	if !inAfter {
+		l = before
+	}
+	c := types.CoinDiff{ID: l.ID, Name: l.Name, Symbol: l.Symbol, Slug: l.Slug}
+
+	if inBefore {
+		c.FromRank = before.CMCRank
+		c.FromPrice = before.Quote["USD"].Price
+		c.FromMarketCap = before.Quote["USD"].MarketCap
+	}
+	if inAfter {
+		c.ToRank = after.CMCRank
+		c.ToPrice = after.Quote["USD"].Price
+		c.ToMarketCap = after.Quote["USD"].MarketCap
+	}
+	if !inBefore || !inAfter {
+		return c
+	}
+
+	c.RankChange = c.FromRank - c.ToRank
+	c.PriceChange = c.ToPrice - c.FromPrice
+	c.PriceChangePercent = percentOf(c.PriceChange, c.FromPrice)
+	c.MarketCapChange = c.ToMarketCap - c.FromMarketCap
+	c.MarketCapChangePercent = percentOf(c.MarketCapChange, c.FromMarketCap)
+	c.TagsAdded = missingFrom(after.Tags, before.Tags)
+	c.TagsRemoved = missingFrom(before.Tags, after.Tags)
+	return c
+}
+
+// missingFrom returns the tags of a that b does not have, sorted.
+func missingFrom(a, b []string) []string {
+	var out []string
+	for _, t := range a {
+		if !contains(b, t) {
+			out = append(out, t)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// biggestMovers returns the n coins whose key changed the most in the
+// direction of sign, which is 1 for gains and -1 for losses. Unchanged
+// coins are never movers.
+func biggestMovers(coins []types.CoinDiff, key func(types.CoinDiff) float64, sign float64, n int) []types.CoinDiff {
+	out := []types.CoinDiff{}
+	for _, c := range coins {
+		if key(c)*sign > 0 {
+			out = append(out, c)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return math.Abs(key(out[i])) > math.Abs(key(out[j]))
+	})
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// signed formats a change with its sign, for the diff page.
+func signed(v float64) string {
+	if v > 0 {
+		return fmt.Sprintf("+%s", shortNumber(v))
+	}
+	return shortNumber(v)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/url"
+	"server/types"
+	"strconv"
+	"strings"
+)
+
+// splitList splits a comma separated parameter, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, strings.ToLower(item))
+		}
+	}
+	return out
+}
+
+// addCMCParams passes the bounds CoinMarketCap can filter on itself, so
+// fewer rows are dropped on our side. Tags and platforms have no usable
+// upstream equivalent and are only applied by filterListings.
+func addCMCParams(f *types.ListingFilter, q url.Values) {
+	if f == nil {
+		return
+	}
+
+	set := func(name string, v *float64) {
+		if v != nil {
+			q.Set(name, strconv.FormatFloat(*v, 'f', -1, 64))
+		}
+	}
+	set("price_min", f.PriceMin)
+	set("price_max", f.PriceMax)
+	set("market_cap_min", f.MarketCapMin)
+	set("market_cap_max", f.MarketCapMax)
+	set("volume_24h_min", f.Volume24hMin)
+}
+
+// filterListings keeps the listings matching every part of the filter. The
+// upstream bounds are checked again so the result does not depend on
+// CoinMarketCap honouring them.
+func filterListings(f *types.ListingFilter, listings []types.CryptoListing) []types.CryptoListing {
+	if f == nil {
+		return listings
+	}
+
+	out := make([]types.CryptoListing, 0, len(listings))
+	for _, listing := range listings {
+		if matchesFilter(f, listing) {
+			out = append(out, listing)
+		}
+	}
+	return out
+}
+
+func matchesFilter(f *types.ListingFilter, listing types.CryptoListing) bool {
+	tags := make(map[string]bool, len(listing.Tags))
+	for _, t := range listing.Tags {
+		tags[strings.ToLower(t)] = true
+	}
+	for _, t := range f.Tags {
+		if !tags[t] {
+			return false
+		}
+	}
+	for _, t := range f.ExcludeTags {
+		if tags[t] {
+			return false
+		}
+	}
+
+	if len(f.Platforms) > 0 {
+		if listing.Platform == nil || !contains(f.Platforms, strings.ToLower(listing.Platform.Slug)) {
+			return false
+		}
+	}
+
+	quote := listing.Quote["USD"]
+	if f.PriceMin != nil && quote.Price < *f.PriceMin {
+		return false
+	}
+	if f.PriceMax != nil && quote.Price > *f.PriceMax {
+		return false
+	}
+	if f.MarketCapMin != nil && quote.MarketCap < *f.MarketCapMin {
+		return false
+	}
+	if f.MarketCapMax != nil && quote.MarketCap > *f.MarketCapMax {
+		return false
+	}
+	if f.Volume24hMin != nil && quote.Volume24h < *f.Volume24hMin {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// shuttingDown flips to true once a termination signal arrives so /readyz
+// fails while in-flight requests drain.
+var shuttingDown atomic.Bool
+
+// readinessCheck is one dependency probed by /readyz. Results are cached for
+// cfg.ReadinessCacheTTL so frequent probes do not hammer the upstreams.
+type readinessCheck struct {
+	name string
+	fn   func(ctx context.Context) error
+
+	mu     sync.Mutex
+	result checkResult
+}
+
+type checkResult struct {
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	LatencyMs int64     `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+var readinessChecks = []*readinessCheck{
+	{name: "coinmarketcap", fn: probeCoinMarketCap},
+	{name: "coingecko", fn: probeCoinGecko},
+	{name: "coin_index", fn: probeCoinIndex},
+	{name: "storage", fn: probeStorage},
+}
+
+func (c *readinessCheck) run(ctx context.Context) checkResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.result.CheckedAt.IsZero() && time.Since(c.result.CheckedAt) < cfg.ReadinessCacheTTL {
+		return c.result
+	}
+
+	// the result is shared with every caller until it expires, so a client
+	// hanging up must not cut the probe short
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cfg.ReadinessTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := c.fn(ctx)
+
+	res := checkResult{Status: "ok", LatencyMs: time.Since(start).Milliseconds(), CheckedAt: time.Now()}
+	if err != nil {
+		res.Status = "fail"
+		res.Error = err.Error()
+		slog.WarnContext(ctx, "Readiness check failed", "check", c.name, "err", err)
+	}
+	// a canceled probe says nothing about the dependency, the next caller
+	// probes again
+	if !errors.Is(err, context.Canceled) {
+		c.result = res
+	}
+	return res
+}
+
+type healthResponse struct {
+	Status string `json:"status"`
+}
+
+type readinessResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// readyzHandler runs every readiness check concurrently and answers 503 when
+// any of them fails, so load balancers stop routing to this instance.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	// the answer is 503 whatever the checks say, do not probe the upstreams
+	if shuttingDown.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, readinessResponse{Status: "shutting_down", Checks: map[string]checkResult{}})
+		return
+	}
+
+	results := make(map[string]checkResult, len(readinessChecks))
+	var resultsMu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, check := range readinessChecks {
+		wg.Add(1)
+		go func(check *readinessCheck) {
+			defer wg.Done()
+			res := check.run(r.Context())
+
+			resultsMu.Lock()
+			results[check.name] = res
+			resultsMu.Unlock()
+		}(check)
+	}
+	wg.Wait()
+
+	status := "ok"
+	code := http.StatusOK
+	for _, res := range results {
+		if res.Status != "ok" {
+			status = "fail"
+			code = http.StatusServiceUnavailable
+		}
+	}
+
+	writeJSON(w, code, readinessResponse{Status: status, Checks: results})
+}
+
+// probeCoinMarketCap calls the key info endpoint, which does not consume
+// plan credits and also fails when our key is revoked.
+func probeCoinMarketCap(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://pro-api.coinmarketcap.com/v1/key/info", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accepts", "application/json")
+	req.Header.Add("X-CMC_PRO_API_KEY", cfg.CMCAPIKey)
+	return probe(req)
+}
+
+func probeCoinGecko(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.coingecko.com/api/v3/ping", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("x-cg-demo-api-key", cfg.CoinGeckoAPIKey)
+	return probe(req)
+}
+
+func probe(req *http.Request) error {
+	resp, err := upstreamClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// probeCoinIndex loads the coin index if it is not there yet, so a fresh
+// instance becomes ready without waiting for its first listings request.
+func probeCoinIndex(ctx context.Context) error {
+	if coinList.loaded() {
+		return nil
+	}
+	if _, err := coinList.get(ctx, upstreamClient); err != nil {
+		return fmt.Errorf("coin index not loaded: %w", err)
+	}
+	return nil
+}
+
+func probeStorage(ctx context.Context) error {
+	if err := os.MkdirAll(cfg.DataDir, 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(cfg.DataDir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	_, werr := f.Write([]byte("ok"))
+	cerr := f.Close()
+	rerr := os.Remove(name)
+
+	return errors.Join(werr, cerr, rerr)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadinessCheckIgnoresCallerCancel(t *testing.T) {
+	calls := 0
+	c := &readinessCheck{name: "test", fn: func(ctx context.Context) error {
+		calls++
+		return ctx.Err()
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if res := c.run(ctx); res.Status != "ok" {
+		t.Errorf("run() with a canceled caller = %+v, want ok", res)
+	}
+
+	c = &readinessCheck{name: "test", fn: func(context.Context) error {
+		calls++
+		return context.Canceled
+	}}
+	c.run(context.Background())
+	c.run(context.Background())
+	if calls != 3 {
+		t.Errorf("probed %d times, want a canceled result not to be cached", calls)
+	}
+}
+
+func TestReadyzShuttingDownSkipsChecks(t *testing.T) {
+	saved := readinessChecks
+	t.Cleanup(func() {
+		readinessChecks = saved
+		shuttingDown.Store(false)
+	})
+
+	probed := false
+	readinessChecks = []*readinessCheck{{name: "test", fn: func(context.Context) error {
+		probed = true
+		return nil
+	}}}
+	shuttingDown.Store(true)
+
+	w := httptest.NewRecorder()
+	readyzHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if probed {
+		t.Errorf("readyz probed the dependencies while shutting down")
+	}
+}
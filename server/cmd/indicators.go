@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	templates "server/html"
+	"server/types"
+	"strings"
+	"sync"
+	"time"
+)
+
+var indicatorNames = []string{"sma", "ema", "rsi", "macd", "bollinger", "atr"}
+
+const (
+	maxIndicatorWindow = 200
+	// cachedIndicators bounds how many computed series are kept in memory.
+	cachedIndicators = 64
+)
+
+// indicatorsCache keeps computed indicator series by request until new
+// candles are folded in.
+type indicatorsCache struct {
+	mu      sync.Mutex
+	entries map[string]indicatorsEntry
+	order   []string // keys, oldest first
+}
+
+type indicatorsEntry struct {
+	folded time.Time
+	series types.IndicatorSeries
+}
+
+var indicatorResults = &indicatorsCache{entries: make(map[string]indicatorsEntry)}
+
+func (c *indicatorsCache) get(key string, folded time.Time) (types.IndicatorSeries, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || !e.folded.Equal(folded) {
+		cacheRequests.inc("indicators", "miss")
+		return types.IndicatorSeries{}, false
+	}
+	cacheRequests.inc("indicators", "hit")
+	return e.series, true
+}
+
+func (c *indicatorsCache) put(key string, folded time.Time, series types.IndicatorSeries) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok {
+		c.order = append(c.order, key)
+	}
+	c.entries[key] = indicatorsEntry{folded: folded, series: series}
+
+	for len(c.order) > cachedIndicators {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// indicatorsHandler serves /api/indicators?coin=&interval=. Indicators are
+// computed from the candles of /api/candles with missing candles filled
+// with the previous close. Enough candles before from are read for the
+// indicators to be settled at from.
+func indicatorsHandler(w http.ResponseWriter, r *http.Request) {
+	p, format, err := clientParams(r)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	ref := p.str("coin")
+	if ref == "" {
+		p.fail("coin", "is required")
+	} else if !validCoinRef(ref) {
+		p.fail("coin", "must be a CoinMarketCap ID, slug or symbol")
+	}
+	interval := p.enum("interval", "1h", candleIntervalNames(), false)
+	currency := snapshotCurrency(p)
+	from, to := candleRange(p, interval)
+
+	selected := p.list("indicators")
+	if len(selected) == 0 {
+		selected = indicatorNames
+	}
+	for _, name := range selected {
+		if !contains(indicatorNames, name) {
+			p.fail("indicators", "%q is not one of %s", name, strings.Join(indicatorNames, ", "))
+		}
+	}
+
+	var windows types.IndicatorWindows
+	if contains(selected, "sma") {
+		windows.SMA = p.integer("sma", 20, 2, maxIndicatorWindow, false)
+	}
+	if contains(selected, "ema") {
+		windows.EMA = p.integer("ema", 20, 2, maxIndicatorWindow, false)
+	}
+	if contains(selected, "rsi") {
+		windows.RSI = p.integer("rsi", 14, 2, maxIndicatorWindow, false)
+	}
+	if contains(selected, "macd") {
+		windows.MACDFast = p.integer("macd_fast", 12, 2, maxIndicatorWindow, false)
+		windows.MACDSlow = p.integer("macd_slow", 26, 2, maxIndicatorWindow, false)
+		windows.MACDSignal = p.integer("macd_signal", 9, 2, maxIndicatorWindow, false)
+		if windows.MACDFast >= windows.MACDSlow {
+			p.fail("macd_fast", "must be less than macd_slow")
+		}
+	}
+	if contains(selected, "bollinger") {
+		windows.Bollinger = p.integer("bollinger", 20, 2, maxIndicatorWindow, false)
+		windows.BollingerK = 2
+		if k := p.number("bollinger_k"); k != nil {
+			if *k == 0 {
+				p.fail("bollinger_k", "must be positive")
+			}
+			windows.BollingerK = *k
+		}
+	}
+	if contains(selected, "atr") {
+		windows.ATR = p.integer("atr", 14, 2, maxIndicatorWindow, false)
+	}
+	if err := p.err(); err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	if !allowRequest(w, r, format) {
+		return
+	}
+
+	coin, err := snapshotCoin(ref)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	key := fmt.Sprintf("%s|%s|%d|%d|%d|%+v", interval, currency, coin.ID, from.Unix(), to.Unix(), windows)
+	folded := candles.lastFolded()
+	series, ok := indicatorResults.get(key, folded)
+	if !ok {
+		series = computeIndicators(coin, interval, currency, from, to, windows)
+		indicatorResults.put(key, folded, series)
+	}
+
+	if format == "json" {
+		writeJSON(w, http.StatusOK, series)
+		return
+	}
+	renderHTML(w, r, http.StatusOK, templates.Indicators, series)
+}
+
+// warmUp is how many candles before the range an indicator with the given
+// longest window needs to settle: the window itself, and for the
+// exponential ones twice that again until the seed has faded out.
+func warmUp(w types.IndicatorWindows) int {
+	longest := max(w.SMA, w.Bollinger, w.EMA, w.RSI, w.ATR, w.MACDSlow+w.MACDSignal)
+	return 3 * longest
+}
+
+func computeIndicators(coin types.CryptoListing, interval, currency string, from, to time.Time, w types.IndicatorWindows) types.IndicatorSeries {
+	size := candleIntervalSize(interval)
+	start := from.Add(-time.Duration(warmUp(w)) * size)
+
+	filled := filledCandles(interval, currency, coin.ID, start, to)
+
+	n := len(filled)
+	high, low, closes := make([]float64, n), make([]float64, n), make([]float64, n)
+	for i, c := range filled {
+		high[i], low[i], closes[i] = c.High, c.Low, c.Close
+	}
+
+	series := types.IndicatorSeries{
+		ID:       coin.ID,
+		Name:     coin.Name,
+		Symbol:   coin.Symbol,
+		Slug:     coin.Slug,
+		Currency: currency,
+		Interval: interval,
+		From:     from,
+		To:       to,
+		Windows:  w,
+		Points:   []types.IndicatorPoint{},
+	}
+
+	var sma, ema, rsi, macd, signal, histogram, upper, middle, lower, atr []float64
+	if w.SMA > 0 {
+		sma = CalculateSMA(closes, w.SMA)
+	}
+	if w.EMA > 0 {
+		ema = CalculateEMA(closes, w.EMA)
+	}
+	if w.RSI > 0 {
+		rsi = CalculateRSI(closes, w.RSI)
+	}
+	if w.MACDSlow > 0 {
+		macd, signal, histogram = CalculateMACD(closes, w.MACDFast, w.MACDSlow, w.MACDSignal)
+	}
+	if w.Bollinger > 0 {
+		upper, middle, lower = CalculateBollinger(closes, w.Bollinger, w.BollingerK)
+	}
+	if w.ATR > 0 {
+		atr = CalculateATR(high, low, closes, w.ATR)
+	}
+
+	for i, c := range filled {
+		if c.Time.Before(from) {
+			continue
+		}
+		series.Points = append(series.Points, types.IndicatorPoint{
+			Time:           c.Time,
+			Close:          c.Close,
+			Filled:         c.Filled,
+			SMA:            pointValue(sma, i),
+			EMA:            pointValue(ema, i),
+			RSI:            pointValue(rsi, i),
+			MACD:           pointValue(macd, i),
+			MACDSignal:     pointValue(signal, i),
+			MACDHistogram:  pointValue(histogram, i),
+			BollingerUpper: pointValue(upper, i),
+			BollingerMid:   pointValue(middle, i),
+			BollingerLower: pointValue(lower, i),
+			ATR:            pointValue(atr, i),
+		})
+	}
+	return series
+}
+
+// filledCandles is the candles of one series in [from, to) with missing
+// candles filled with the previous close, up to the newest snapshot folded
+// in.
+func filledCandles(interval, currency string, id int, from, to time.Time) []types.Candle {
+	size := candleIntervalSize(interval)
+	found, prev, folded := candles.window(interval, currency, id, from, to)
+	until := folded.Truncate(size).Add(size)
+	if until.After(to) {
+		until = to
+	}
+	filled, _ := fillGaps(found, prev, from, until, size, true)
+	return filled
+}
+
+// pointValue is values[i], nil when the indicator was not requested or is
+// not defined there yet.
+func pointValue(values []float64, i int) *float64 {
+	if values == nil || math.IsNaN(values[i]) {
+		return nil
+	}
+	v := values[i]
+	return &v
+}
@@ -0,0 +1,606 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	templates "server/html"
+	"server/types"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	indexMethods     = []string{"top", "fixed"}
+	indexWeightings  = []string{"market_cap", "equal"}
+	rebalanceOptions = []string{"none", "daily", "weekly", "monthly"}
+)
+
+const (
+	maxIndexName         = 64
+	maxIndexConstituents = 50
+	indexBaseValue       = 1000
+)
+
+// indexStore keeps the custom indices of every client, with their NAV
+// history within cfg.SnapshotRetention, in one JSON file under cfg.DataDir,
+// keyed by ownerID. Indices are valued on every snapshot, see update.
+type indexStore struct {
+	mu      sync.Mutex
+	path    string
+	indices map[string][]types.Index
+}
+
+var indices *indexStore
+
+func openIndexStore(dir string) (*indexStore, error) {
+	s := &indexStore{
+		path:    filepath.Join(dir, "indices.json"),
+		indices: make(map[string][]types.Index),
+	}
+	if err := readJSONFile(s.path, &s.indices); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// flush writes the indices on shutdown. update keeps the advanced NAVs in
+// memory when writing them fails, the next write or this one stores them.
+func (s *indexStore) flush(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return writeJSONFile(s.path, s.indices)
+}
+
+// list returns the indices of apiKey in the order they were created,
+// without their history.
+func (s *indexStore) list(apiKey string) []types.Index {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := s.indices[ownerID(apiKey)]
+	list := make([]types.Index, len(stored))
+	for i, idx := range stored {
+		list[i] = copyIndex(idx)
+		list[i].History = nil
+	}
+	return list
+}
+
+// get returns one index of apiKey with its history.
+func (s *indexStore) get(apiKey, id string) (types.Index, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, idx := range s.indices[ownerID(apiKey)] {
+		if idx.ID == id {
+			return copyIndex(idx), true
+		}
+	}
+	return types.Index{}, false
+}
+
+func (s *indexStore) add(apiKey string, idx types.Index) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	owner := ownerID(apiKey)
+	prev := s.indices[owner]
+	s.indices[owner] = append(prev[:len(prev):len(prev)], idx)
+	if err := writeJSONFile(s.path, s.indices); err != nil {
+		s.indices[owner] = prev
+		return err
+	}
+	return nil
+}
+
+// remove deletes one index and reports whether it existed.
+func (s *indexStore) remove(apiKey, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	owner := ownerID(apiKey)
+	prev := s.indices[owner]
+	kept := make([]types.Index, 0, len(prev))
+	for _, idx := range prev {
+		if idx.ID != id {
+			kept = append(kept, idx)
+		}
+	}
+	if len(kept) == len(prev) {
+		return false, nil
+	}
+
+	if len(kept) == 0 {
+		delete(s.indices, owner)
+	} else {
+		s.indices[owner] = kept
+	}
+	if err := writeJSONFile(s.path, s.indices); err != nil {
+		s.indices[owner] = prev
+		return false, err
+	}
+	return true, nil
+}
+
+// update values every index at the quotes of snap, rebalancing the ones
+// that are due, and stores the result.
+func (s *indexStore) update(snap types.Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.indices) == 0 {
+		return nil
+	}
+	byID := listingsByID(snap.Listings)
+	for _, list := range s.indices {
+		for i := range list {
+			advanceIndex(&list[i], snap.Listings, byID, snap.Time)
+			if cfg.SnapshotRetention > 0 {
+				pruneNAV(&list[i], snap.Time.Add(-cfg.SnapshotRetention))
+			}
+		}
+	}
+	return writeJSONFile(s.path, s.indices)
+}
+
+// copyIndex copies the slices of idx, so the caller can use it without
+// holding the store lock.
+func copyIndex(idx types.Index) types.Index {
+	idx.ExcludeTags = append([]string(nil), idx.ExcludeTags...)
+	idx.Weights = append([]types.IndexWeight(nil), idx.Weights...)
+	idx.Constituents = append([]types.IndexConstituent(nil), idx.Constituents...)
+	idx.History = append([]types.IndexPoint(nil), idx.History...)
+	return idx
+}
+
+// advanceIndex revalues idx at the quotes of a new snapshot taken at t and
+// rebalances it when a rebalance is due. Snapshots not newer than the last
+// one seen are ignored.
+func advanceIndex(idx *types.Index, listings []types.CryptoListing, byID map[int]types.CryptoListing, t time.Time) {
+	if !t.After(idx.Updated) {
+		return
+	}
+	repriceIndex(idx, byID)
+	if idx.NextRebalance != nil && !t.Before(*idx.NextRebalance) {
+		rebalanceIndex(idx, listings, byID, t)
+	}
+	recordNAV(idx, t)
+}
+
+// rebalanceIndex invests the NAV of idx in its targets at the current
+// quotes. A fixed constituent without a quote cannot be traded, it keeps
+// its units and the rest of the NAV is spread over the others.
+func rebalanceIndex(idx *types.Index, listings []types.CryptoListing, byID map[int]types.CryptoListing, t time.Time) {
+	held := make(map[int]types.IndexConstituent, len(idx.Constituents))
+	for _, c := range idx.Constituents {
+		held[c.CoinID] = c
+	}
+
+	invest := idx.NAV
+	var constituents []types.IndexConstituent
+	var priced []types.IndexWeight
+	var pricedWeight float64
+	for _, target := range indexTargets(idx.IndexDefinition, listings) {
+		if byID[target.CoinID].Quote["USD"].Price > 0 {
+			priced = append(priced, target)
+			pricedWeight += target.Weight
+			continue
+		}
+		if c, ok := held[target.CoinID]; ok {
+			c.TargetWeight = target.Weight
+			constituents = append(constituents, c)
+			invest -= c.Value
+		}
+	}
+
+	idx.LastRebalance = t
+	idx.NextRebalance = nextRebalance(idx.Rebalance, t)
+	// nothing can be bought, keep what is held
+	if len(priced) == 0 {
+		return
+	}
+
+	for _, target := range priced {
+		l := byID[target.CoinID]
+		price := l.Quote["USD"].Price
+		constituents = append(constituents, types.IndexConstituent{
+			CoinID:       l.ID,
+			Name:         l.Name,
+			Symbol:       l.Symbol,
+			Slug:         l.Slug,
+			Units:        invest * target.Weight / pricedWeight / price,
+			Price:        price,
+			TargetWeight: target.Weight,
+		})
+	}
+	idx.Constituents = constituents
+	repriceIndex(idx, byID)
+}
+
+// repriceIndex values the constituents of idx at their USD quotes in byID,
+// or at their last price when they have none, largest first.
+func repriceIndex(idx *types.Index, byID map[int]types.CryptoListing) {
+	idx.NAV = 0
+	for i := range idx.Constituents {
+		c := &idx.Constituents[i]
+		if price := byID[c.CoinID].Quote["USD"].Price; price > 0 {
+			c.Price = price
+			c.Stale = false
+		} else {
+			c.Stale = true
+		}
+		c.Value = c.Units * c.Price
+		idx.NAV += c.Value
+	}
+	for i := range idx.Constituents {
+		idx.Constituents[i].Weight = percentOf(idx.Constituents[i].Value, idx.NAV)
+	}
+	sort.SliceStable(idx.Constituents, func(i, j int) bool {
+		return idx.Constituents[i].Value > idx.Constituents[j].Value
+	})
+}
+
+func recordNAV(idx *types.Index, t time.Time) {
+	idx.Updated = t
+	idx.ChangePercent = percentOf(idx.NAV-idx.BaseValue, idx.BaseValue)
+	idx.History = append(idx.History, types.IndexPoint{Time: t, NAV: idx.NAV})
+}
+
+// pruneNAV drops the NAV points of idx recorded before cutoff. The index
+// itself keeps its NAV and change since creation.
+func pruneNAV(idx *types.Index, cutoff time.Time) {
+	i := sort.Search(len(idx.History), func(i int) bool { return !idx.History[i].Time.Before(cutoff) })
+	idx.History = idx.History[i:]
+}
+
+// indexTargets is the composition def asks for among listings, weights in
+// percent adding up to 100.
+func indexTargets(def types.IndexDefinition, listings []types.CryptoListing) []types.IndexWeight {
+	var targets []types.IndexWeight
+	switch def.Method {
+	case "fixed":
+		targets = append(targets, def.Weights...)
+	case "top":
+		exclude := &types.ListingFilter{ExcludeTags: def.ExcludeTags}
+		candidates := make([]types.CryptoListing, 0, len(listings))
+		for _, l := range listings {
+			if q := l.Quote["USD"]; q.Price > 0 && q.MarketCap > 0 && matchesFilter(exclude, l) {
+				candidates = append(candidates, l)
+			}
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].Quote["USD"].MarketCap > candidates[j].Quote["USD"].MarketCap
+		})
+		if len(candidates) > def.Top {
+			candidates = candidates[:def.Top]
+		}
+		for _, l := range candidates {
+			weight := 1.0
+			if def.Weighting == "market_cap" {
+				weight = l.Quote["USD"].MarketCap
+			}
+			targets = append(targets, types.IndexWeight{CoinID: l.ID, Name: l.Name, Symbol: l.Symbol, Weight: weight})
+		}
+	}
+
+	var total float64
+	for _, t := range targets {
+		total += t.Weight
+	}
+	if total == 0 {
+		return nil
+	}
+	weights := make([]float64, len(targets))
+	for i := range targets {
+		weights[i] = targets[i].Weight / total * 100
+	}
+	capWeights(weights, def.Cap)
+	for i := range targets {
+		targets[i].Weight = weights[i]
+	}
+	return targets
+}
+
+// capWeights limits every weight to limit percent and hands what was cut
+// to the weights below the limit in proportion to their size, until none
+// is above it. A limit too low for the number of weights is raised to an
+// equal split. Zero means no limit.
+func capWeights(weights []float64, limit float64) {
+	if limit <= 0 || len(weights) == 0 {
+		return
+	}
+	limit = max(limit, 100/float64(len(weights)))
+
+	for {
+		var excess, free float64
+		for i, w := range weights {
+			if w > limit {
+				excess += w - limit
+				weights[i] = limit
+			} else if w < limit {
+				free += w
+			}
+		}
+		if excess < 1e-9 || free == 0 {
+			return
+		}
+		for i, w := range weights {
+			if w < limit {
+				weights[i] += excess * w / free
+			}
+		}
+	}
+}
+
+// nextRebalance is the first time after t a rule rebalances at: midnight
+// UTC for daily, Monday midnight for weekly, the first of the month for
+// monthly. none never rebalances.
+func nextRebalance(rule string, t time.Time) *time.Time {
+	day := t.UTC().Truncate(24 * time.Hour)
+	var next time.Time
+	switch rule {
+	case "daily":
+		next = day.AddDate(0, 0, 1)
+	case "weekly":
+		days := (8 - int(day.Weekday())) % 7
+		if days == 0 {
+			days = 7
+		}
+		next = day.AddDate(0, 0, days)
+	case "monthly":
+		next = time.Date(day.Year(), day.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return nil
+	}
+	return &next
+}
+
+// indicesHandler serves /api/indices. GET lists the caller's indices at
+// their latest NAV, POST creates one.
+func indicesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeError(w, r, preferredFormat(r), errMethodNotAllowed(http.MethodGet, http.MethodPost))
+		return
+	}
+
+	p, format, err := clientParams(r)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+	if r.Method == http.MethodPost {
+		createIndex(w, r, p, format)
+		return
+	}
+	if err := p.err(); err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	if !allowRequest(w, r, format) {
+		return
+	}
+
+	apiKey := p.str("api-key")
+	list := types.IndexList{Indices: indices.list(apiKey), Caller: types.Caller{APIKey: apiKey}}
+	if format == "json" {
+		writeJSON(w, http.StatusOK, list)
+		return
+	}
+	renderHTML(w, r, http.StatusOK, templates.Indices, list)
+}
+
+// createIndex serves POST /api/indices. The index starts at indexBaseValue,
+// invested at the quotes of the newest snapshot, and is valued on every
+// snapshot after it.
+func createIndex(w http.ResponseWriter, r *http.Request, p *params, format string) {
+	def := types.IndexDefinition{
+		Name:      p.str("name"),
+		Method:    p.enum("method", "top", indexMethods, false),
+		Rebalance: p.enum("rebalance", "monthly", rebalanceOptions, false),
+		BaseValue: indexBaseValue,
+	}
+	if def.Name == "" {
+		p.fail("name", "is required")
+	} else if len(def.Name) > maxIndexName {
+		p.fail("name", "at most %d characters", maxIndexName)
+	}
+
+	var weights map[string]float64
+	var refs []string
+	size := 0
+	switch def.Method {
+	case "top":
+		def.Top = p.integer("top", 10, 1, maxIndexConstituents, false)
+		def.Weighting = p.enum("weighting", "market_cap", indexWeightings, false)
+		def.ExcludeTags = p.list("exclude_tags")
+		size = def.Top
+	case "fixed":
+		refs, weights = parseIndexWeights(p)
+		size = len(refs)
+	}
+	if limit := p.number("cap"); limit != nil {
+		switch {
+		case *limit == 0 || *limit > 100:
+			p.fail("cap", "must be a percentage above 0 and at most 100")
+		case size > 0 && *limit*float64(size) < 100:
+			p.fail("cap", "is too low for %d constituents to add up to 100%%", size)
+		}
+		def.Cap = *limit
+	}
+	if err := p.err(); err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	if !allowRequest(w, r, format) {
+		return
+	}
+
+	snap, err := latestSnapshot()
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+	for _, ref := range refs {
+		l, ok := findListing(snap.Listings, ref)
+		if !ok {
+			p.fail("weights", "%q is not in the newest snapshot", ref)
+			continue
+		}
+		for _, w := range def.Weights {
+			if w.CoinID == l.ID {
+				p.fail("weights", "%q is listed twice", ref)
+			}
+		}
+		def.Weights = append(def.Weights, types.IndexWeight{CoinID: l.ID, Name: l.Name, Symbol: l.Symbol, Weight: weights[ref]})
+	}
+	if err := p.err(); err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	if def.ID, err = newID(); err != nil {
+		writeError(w, r, format, errInternal(err))
+		return
+	}
+	def.Created = snap.Time
+	idx := types.Index{IndexDefinition: def, NAV: def.BaseValue}
+	rebalanceIndex(&idx, snap.Listings, listingsByID(snap.Listings), snap.Time)
+	if len(idx.Constituents) == 0 {
+		p.fail("exclude_tags", "no coin of the newest snapshot is left to hold")
+		writeError(w, r, format, p.err())
+		return
+	}
+	recordNAV(&idx, snap.Time)
+
+	apiKey := p.str("api-key")
+	if err := indices.add(apiKey, idx); err != nil {
+		writeError(w, r, format, errInternal(err))
+		return
+	}
+
+	idx.APIKey = apiKey
+	w.Header().Set("Location", "/api/indices/"+idx.ID)
+	if format == "json" {
+		writeJSON(w, http.StatusCreated, idx)
+		return
+	}
+	renderHTML(w, r, http.StatusCreated, templates.Basket, idx)
+}
+
+// parseIndexWeights reads weights as comma separated coin:weight pairs,
+// for example BTC:60,ETH:40. Weights are relative, they need not add up to
+// 100.
+func parseIndexWeights(p *params) (refs []string, weights map[string]float64) {
+	pairs := p.list("weights")
+	switch {
+	case len(pairs) == 0:
+		p.fail("weights", "is required for a fixed index")
+		return nil, nil
+	case len(pairs) > maxIndexConstituents:
+		p.fail("weights", "at most %d coins", maxIndexConstituents)
+		return nil, nil
+	}
+
+	weights = make(map[string]float64, len(pairs))
+	for _, pair := range pairs {
+		ref, value, ok := strings.Cut(pair, ":")
+		ref = strings.TrimSpace(ref)
+		weight, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		switch {
+		case !ok || !validCoinRef(ref):
+			p.fail("weights", "%q is not a coin:weight pair", pair)
+			continue
+		case err != nil || !(weight > 0) || weight > 1e9:
+			p.fail("weights", "the weight of %q must be a positive number", ref)
+			continue
+		}
+		refs = append(refs, ref)
+		weights[ref] += weight
+	}
+	return refs, weights
+}
+
+// indexHandler serves /api/indices/{index}: GET and POST show the index
+// with its NAV history between from and to, DELETE removes it.
+func indexHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		writeError(w, r, preferredFormat(r), errMethodNotAllowed(http.MethodGet, http.MethodPost, http.MethodDelete))
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/indices/")
+
+	p, format, err := clientParams(r)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+	from := p.timestamp("from")
+	to := p.timestamp("to")
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		p.fail("from", "must be before to")
+	}
+	if err := p.err(); err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	if !allowRequest(w, r, format) {
+		return
+	}
+
+	apiKey := p.str("api-key")
+	notFound := errNotFound("No index with ID " + id + ".")
+
+	if r.Method == http.MethodDelete {
+		removed, err := indices.remove(apiKey, id)
+		if err != nil {
+			writeError(w, r, format, errInternal(err))
+			return
+		}
+		if !removed {
+			writeError(w, r, format, notFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	idx, ok := indices.get(apiKey, id)
+	if !ok {
+		writeError(w, r, format, notFound)
+		return
+	}
+	idx.History = historyBetween(idx.History, func(point types.IndexPoint) time.Time { return point.Time }, from, to)
+	idx.APIKey = apiKey
+
+	if format == "json" {
+		writeJSON(w, http.StatusOK, idx)
+		return
+	}
+	renderHTML(w, r, http.StatusOK, templates.Basket, idx)
+}
+
+// historyBetween is the points of history, oldest first, whose timeOf is
+// in [from, to], either bound open when zero, at most the newest maxCandles
+// of them.
+func historyBetween[T any](history []T, timeOf func(T) time.Time, from, to time.Time) []T {
+	i := sort.Search(len(history), func(i int) bool { return !timeOf(history[i]).Before(from) })
+	j := len(history)
+	if !to.IsZero() {
+		j = sort.Search(len(history), func(i int) bool { return timeOf(history[i]).After(to) })
+	}
+	if j < i {
+		j = i
+	}
+	if j-i > maxCandles {
+		i = j - maxCandles
+	}
+	return history[i:j]
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"server/types"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// listingsCache keeps the top cfg.QuoteListingLimit listings by market cap.
+// Endpoints that only need current quotes for known coins read it instead
+// of calling CoinMarketCap on every request.
+type listingsCache struct {
+	mu       sync.Mutex
+	listings []types.CryptoListing
+	fetched  time.Time
+}
+
+var latestListings = &listingsCache{}
+
+func (c *listingsCache) get(ctx context.Context, client *http.Client) ([]types.CryptoListing, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.listings != nil && time.Since(c.fetched) < cfg.ListingsCacheTTL {
+		cacheRequests.inc("listings", "hit")
+		return c.listings, nil
+	}
+	cacheRequests.inc("listings", "miss")
+
+	resp, err := fetchListings(ctx, client, listingsQuery{
+		Start:   1,
+		Limit:   cfg.QuoteListingLimit,
+		Order:   "market_cap",
+		SortDir: "desc",
+	})
+	if err != nil {
+		// quotes a few minutes old beat an error page
+		if c.listings != nil {
+			return c.listings, nil
+		}
+		return nil, err
+	}
+
+	c.listings = resp.Data
+	c.fetched = time.Now()
+	return c.listings, nil
+}
+
+// cached returns the listings from the last fetch without fetching, nil
+// before the first one.
+func (c *listingsCache) cached() []types.CryptoListing {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.listings
+}
+
+// listingsByID indexes listings by CoinMarketCap ID.
+func listingsByID(listings []types.CryptoListing) map[int]types.CryptoListing {
+	byID := make(map[int]types.CryptoListing, len(listings))
+	for _, l := range listings {
+		byID[l.ID] = l
+	}
+	return byID
+}
+
+// findListing resolves ref the way the coin endpoint does: numbers are IDs,
+// anything else is matched as a slug first and then as a symbol. Symbols
+// are not unique, the listing ranked highest wins.
+func findListing(listings []types.CryptoListing, ref string) (types.CryptoListing, bool) {
+	if id, err := strconv.Atoi(ref); err == nil {
+		for _, l := range listings {
+			if l.ID == id {
+				return l, true
+			}
+		}
+		return types.CryptoListing{}, false
+	}
+
+	for _, l := range listings {
+		if strings.EqualFold(l.Slug, ref) {
+			return l, true
+		}
+	}
+	for _, l := range listings {
+		if strings.EqualFold(l.Symbol, ref) {
+			return l, true
+		}
+	}
+	return types.CryptoListing{}, false
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+const redacted = "[REDACTED]"
+
+// secrets shorter than this are only redacted where they stand alone, not
+// inside a longer word or number: the default api-key 123 must go, the
+// 1234 of an unrelated value must stay
+const minRedactLength = 8
+
+// attribute names whose values are never logged
+var sensitiveKeys = map[string]bool{
+	"api-key":           true,
+	"api_key":           true,
+	"apikey":            true,
+	"authorization":     true,
+	"x-cmc_pro_api_key": true,
+	"x-cg-demo-api-key": true,
+}
+
+type ctxKey int
+
+const requestIDKey ctxKey = iota
+
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+func newLogger(w io.Writer, cfg Config) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		return nil, fmt.Errorf("invalid LOG_LEVEL %q: %w", cfg.LogLevel, err)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler
+	switch cfg.LogFormat {
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid LOG_FORMAT %q: must be json or text", cfg.LogFormat)
+	}
+
+	return slog.New(&contextHandler{next: h, secrets: newSecretScrubber(append([]string{cfg.CMCAPIKey, cfg.CoinGeckoAPIKey}, cfg.APIKeys...)...)}), nil
+}
+
+// secretScrubber replaces the configured keys in log text, whatever their
+// length.
+type secretScrubber struct {
+	long  *strings.Replacer
+	short []string
+}
+
+func newSecretScrubber(secrets ...string) *secretScrubber {
+	var pairs []string
+	var short []string
+	for _, s := range secrets {
+		switch {
+		case s == "":
+		case len(s) >= minRedactLength:
+			pairs = append(pairs, s, redacted)
+		default:
+			short = append(short, s)
+		}
+	}
+	return &secretScrubber{long: strings.NewReplacer(pairs...), short: short}
+}
+
+func (r *secretScrubber) Replace(s string) string {
+	s = r.long.Replace(s)
+	for _, secret := range r.short {
+		s = replaceStandalone(s, secret)
+	}
+	return s
+}
+
+// replaceStandalone redacts the occurrences of secret in s that are not
+// part of a longer run of letters and digits.
+func replaceStandalone(s, secret string) string {
+	var b strings.Builder
+	last := 0
+	for i := 0; ; {
+		j := strings.Index(s[i:], secret)
+		if j < 0 {
+			break
+		}
+		start, end := i+j, i+j+len(secret)
+		if (start == 0 || !isAlphanumeric(s[start-1])) && (end == len(s) || !isAlphanumeric(s[end])) {
+			b.WriteString(s[last:start])
+			b.WriteString(redacted)
+			last, i = end, end
+			continue
+		}
+		i = start + 1
+	}
+	if last == 0 {
+		return s
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+func isAlphanumeric(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// contextHandler adds the request ID from the context to every record and
+// scrubs secrets from the message and attributes before passing it on.
+type contextHandler struct {
+	next    slog.Handler
+	secrets *secretScrubber
+}
+
+func (h *contextHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *contextHandler) Handle(ctx context.Context, rec slog.Record) error {
+	out := slog.NewRecord(rec.Time, rec.Level, h.secrets.Replace(rec.Message), rec.PC)
+	if id := requestIDFrom(ctx); id != "" {
+		out.AddAttrs(slog.String("request_id", id))
+	}
+	rec.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(h.redact(a))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clean := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		clean[i] = h.redact(a)
+	}
+	return &contextHandler{next: h.next.WithAttrs(clean), secrets: h.secrets}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{next: h.next.WithGroup(name), secrets: h.secrets}
+}
+
+func (h *contextHandler) redact(a slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, redacted)
+	}
+
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, h.secrets.Replace(v.String()))
+	case slog.KindGroup:
+		group := v.Group()
+		clean := make([]any, len(group))
+		for i, g := range group {
+			clean[i] = h.redact(g)
+		}
+		return slog.Group(a.Key, clean...)
+	case slog.KindAny:
+		// errors and other values are flattened so a secret embedded in
+		// them (a URL in a transport error, say) cannot slip through
+		s := fmt.Sprint(v.Any())
+		if r := h.secrets.Replace(s); r != s {
+			return slog.String(a.Key, r)
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+// withRequestID reuses the caller's X-Request-ID when it looks sane and
+// generates one otherwise. The ID is echoed in the response and stored in
+// the request context for the logger.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// statusRecorder remembers the status code written by the wrapped handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// withAccessLog logs one line per request. Only the path is logged, the
+// query string may carry the api-key.
+func withAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		slog.InfoContext(r.Context(), "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration", time.Since(start),
+			"remote", r.RemoteAddr,
+		)
+	})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLoggerRedactsKeys(t *testing.T) {
+	c := cfg
+	c.LogLevel, c.LogFormat = "info", "text"
+	c.APIKeys, c.CMCAPIKey, c.CoinGeckoAPIKey = []string{"123"}, "cmc-0123456789abcdef", ""
+
+	tests := []struct {
+		name string
+		log  func(l *slog.Logger)
+		want []string
+		not  []string
+	}{
+		{
+			name: "short key in the message",
+			log:  func(l *slog.Logger) { l.Info("GET /api/market?api-key=123&top=5") },
+			want: []string{"api-key=[REDACTED]&top=5"},
+			not:  []string{"=123"},
+		},
+		{
+			name: "short key inside longer numbers stays",
+			log:  func(l *slog.Logger) { l.Info("listings", "limit", "1234", "ids", "a123,1230") },
+			want: []string{"limit=1234", "ids=a123,1230"},
+		},
+		{
+			name: "short key alone and in an error",
+			log: func(l *slog.Logger) {
+				l.Warn("failed", "key", "123", "err", errors.New(`Get "https://example.com/?api-key=123": timeout`))
+			},
+			want: []string{"key=[REDACTED]", "api-key=[REDACTED]"},
+			not:  []string{"=123"},
+		},
+		{
+			name: "long key anywhere",
+			log:  func(l *slog.Logger) { l.Info("header X-CMC_PRO_API_KEY:cmc-0123456789abcdefx") },
+			want: []string{"X-CMC_PRO_API_KEY:[REDACTED]x"},
+			not:  []string{"0123456789abcdef"},
+		},
+		{
+			name: "sensitive attribute names",
+			log:  func(l *slog.Logger) { l.Info("request", "api-key", "other") },
+			want: []string{"api-key=[REDACTED]"},
+			not:  []string{"other"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			l, err := newLogger(&buf, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.log(l)
+
+			line := buf.String()
+			for _, w := range tt.want {
+				if !strings.Contains(line, w) {
+					t.Errorf("log line %q does not contain %q", line, w)
+				}
+			}
+			for _, n := range tt.not {
+				if strings.Contains(line, n) {
+					t.Errorf("log line %q contains %q", line, n)
+				}
+			}
+		})
+	}
+}
+
+func TestReplaceStandalone(t *testing.T) {
+	tests := []struct {
+		s, secret, want string
+	}{
+		{"123", "123", redacted},
+		{"x=123&y=123", "123", "x=" + redacted + "&y=" + redacted},
+		{"1123 1234 123", "123", "1123 1234 " + redacted},
+		{"a-a-a", "a-a", redacted + "-a"},
+		{"no secret", "123", "no secret"},
+	}
+	for _, tt := range tests {
+		if got := replaceStandalone(tt.s, tt.secret); got != tt.want {
+			t.Errorf("replaceStandalone(%q, %q) = %q, want %q", tt.s, tt.secret, got, tt.want)
+		}
+	}
+}
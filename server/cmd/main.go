@@ -1,15 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"html/template"
 	"log"
-	"math"
+	"log/slog"
 	"net/http"
+	"os"
 	templates "server/html"
 	"server/types"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -19,11 +21,92 @@ import (
 )
 
 func main() {
+	var err error
+	cfg, err = loadConfig()
+	if err != nil {
+		log.Fatal("Error loading config: ", err)
+	}
+
+	logger, err := newLogger(os.Stderr, cfg)
+	if err != nil {
+		log.Fatal("Error configuring logger: ", err)
+	}
+	slog.SetDefault(logger)
+
+	handler, err := newHandler()
+	if err != nil {
+		slog.Error("Refusing to start", "err", err)
+		os.Exit(1)
+	}
+
+	if err := run(cfg, handler); err != nil {
+		slog.Error("Server failed", "err", err)
+		os.Exit(1)
+	}
+}
+
+// newHandler registers every route and wraps the mux in the middleware
+// chain.
+func newHandler() (http.Handler, error) {
+	spec := buildSpec()
+
+	var err error
+	if portfolios, err = openPortfolioStore(cfg.DataDir); err != nil {
+		return nil, fmt.Errorf("open portfolio store: %w", err)
+	}
+	if transactions, err = openTransactionStore(cfg.DataDir); err != nil {
+		return nil, fmt.Errorf("open transaction store: %w", err)
+	}
+	if snapshots, err = openSnapshotStore(cfg.DataDir); err != nil {
+		return nil, fmt.Errorf("open snapshot store: %w", err)
+	}
+	if candles, err = openCandleStore(cfg.DataDir, snapshots); err != nil {
+		return nil, fmt.Errorf("open candle store: %w", err)
+	}
+	if indices, err = openIndexStore(cfg.DataDir); err != nil {
+		return nil, fmt.Errorf("open index store: %w", err)
+	}
+	if pegs, err = openPegStore(cfg.DataDir); err != nil {
+		return nil, fmt.Errorf("open peg store: %w", err)
+	}
+	// hooks run in reverse, so the poller stops before the stores flush
+	onShutdown("portfolio store", portfolios.flush)
+	onShutdown("transaction store", transactions.flush)
+	onShutdown("candle store", candles.flush)
+	onShutdown("index store", indices.flush)
+	onShutdown("peg store", pegs.flush)
+	if cfg.SnapshotInterval > 0 {
+		startSnapshotPoller()
+	}
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", homeHandler)
-	mux.HandleFunc("/api/get-listings", handleApiRequest)
-	http.ListenAndServe(":8080", mux)
-	fmt.Println("Server is running on port 8080")
+	handle(mux, "/", homeHandler)
+	handle(mux, "/api/get-listings", handleApiRequest)
+	handle(mux, "/api/coins/", coinHandler)
+	handle(mux, "/api/compare", compareHandler)
+	handle(mux, "/api/search", searchHandler)
+	handle(mux, "/api/diff", diffHandler)
+	handle(mux, "/api/candles", candlesHandler)
+	handle(mux, "/api/indicators", indicatorsHandler)
+	handle(mux, "/api/risk", riskHandler)
+	handle(mux, "/api/correlation", correlationHandler)
+	handle(mux, "/api/market", marketHandler)
+	handle(mux, "/api/stablecoins", stablecoinsHandler)
+	handle(mux, "/api/stablecoins/", stablecoinHandler)
+	handle(mux, "/api/indices", indicesHandler)
+	handle(mux, "/api/indices/", indexHandler)
+	handle(mux, "/api/portfolio", portfolioHandler)
+	handle(mux, "/api/portfolio/holdings", holdingsHandler)
+	handle(mux, "/api/portfolio/holdings/", holdingHandler)
+	handle(mux, "/api/portfolio/transactions", transactionsHandler)
+	handle(mux, "/api/portfolio/pnl", pnlHandler)
+	handle(mux, "/metrics", metricsHandler)
+	handle(mux, "/healthz", healthzHandler)
+	handle(mux, "/readyz", readyzHandler)
+	handle(mux, "/openapi.json", openapiHandler(spec))
+	handle(mux, "/docs", docsHandler)
+
+	return withRequestID(withAccessLog(withMetrics(mux))), nil
 }
 
 type ClientInfo struct {
@@ -51,68 +134,84 @@ func getOrCreateLimiter(ip string) *rate.Limiter {
 	return info.Limiter
 }
 
+// allowRequest applies the per-client rate limit and answers 429 when it
+// is exceeded.
+func allowRequest(w http.ResponseWriter, r *http.Request, format string) bool {
+	// limiting
+	ip := r.RemoteAddr
+	limiter := getOrCreateLimiter(ip)
+	if !limiter.Allow() {
+		rateLimitRejections.inc()
+		writeError(w, r, format, errRateLimited())
+		return false
+	}
+	return true
+}
+
+// orderOptions are the sort fields accepted by the listings endpoint. The
+// OpenAPI document is generated from the same list.
+var orderOptions = []string{
+	"market_cap",
+	"volume_24h",
+	"percent_change_1h",
+	"percent_change_24h",
+	"percent_change_7d",
+	"price",
+	"name",
+	"symbol",
+}
 
 func handleApiRequest(w http.ResponseWriter, r *http.Request) {
-	client := &http.Client{}
+	client := upstreamClient
 
-	if r.FormValue("api-key") != "123" {
-		http.Error(w, "Invalid API key", http.StatusUnauthorized)
+	req, err := parseListingsRequest(r)
+	if req.Values != nil && !validAPIKey(req.APIKey) {
+		writeError(w, r, req.Format, errInvalidAPIKey())
 		return
 	}
-
-	limit := r.FormValue("limit")
-	order := r.FormValue("order")
-
-	// if limit is lower or equal to 0 return error
-	if limit <= "0" || limit == "" {
-		http.Error(w, "Limit must be set and must be greater than 0", http.StatusBadRequest)
+	if err != nil {
+		writeError(w, r, req.Format, err)
 		return
 	}
+	query := req.Query
+	filter := query.Filter
 
-	// if order is not in options return error
-	options := []string{
-		"market_cap",
-		"volume_24h",
-		"percent_change_1h",
-		"percent_change_24h",
-		"percent_change_7d",
-		"price",
-		"name",
-		"symbol",
-	}
-
-	if !contains(options, order) || order == "" {
-		http.Error(w, "Order must be one of: market_cap, volume_24h, percent_change_1h, percent_change_24h, percent_change_7d, price, name, symbol", http.StatusBadRequest)
+	if !allowRequest(w, r, req.Format) {
 		return
 	}
 
-	// limiting
-	ip := r.RemoteAddr
-	limiter := getOrCreateLimiter(ip)
-	if !limiter.Allow() {
-		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+	if !req.At.IsZero() {
+		serveSnapshotListings(w, r, req)
 		return
 	}
 
 	responseDataCh := make(chan types.Response)
 	responseDataCh2 := make(chan []types.Coin)
-	priceOfCoinOtherApiCh := make(chan string)
+	priceOfCoinOtherApiCh := make(chan string, 1)
+
+	// rows returned upstream before filtering and the upstream error, both
+	// read after responseDataCh
+	var fetched int
+	var listingsErr error
 
 	go func() {
-		responseData, err := createCoinMarketCapRequest(w, r, client)
+		responseData, err := fetchListings(r.Context(), client, query)
 
 		if err != nil {
-			log.Println("Error creating coinmarketcap request:", err)
+			listingsErr = err
+		} else {
+			fetched = len(responseData.Data)
+			responseData.Data = filterListings(filter, responseData.Data)
 		}
 		responseDataCh <- responseData
 		responseDataCh <- responseData
 	}()
 
 	go func() {
-		responseData2, err := createCoinGeckoRequest(w, client)
+		responseData2, err := coinList.get(r.Context(), client)
 
 		if err != nil {
-			log.Println("Error creating coingecko request:", err)
+			slog.ErrorContext(r.Context(), "Error creating coingecko request", "err", err)
 		}
 		responseDataCh2 <- responseData2
 
@@ -122,9 +221,14 @@ func handleApiRequest(w http.ResponseWriter, r *http.Request) {
 		responseData := <-responseDataCh
 		responseData2 := <-responseDataCh2
 
-		// empty data from coinmarketcap
+		// coinmarketcap failed, the handler reports the error
 		if responseData.Data == nil {
-			log.Println("No data from coinmarketcap.")
+			return
+		}
+
+		// every listing was filtered out, there is nothing to cross-check
+		if len(responseData.Data) == 0 {
+			priceOfCoinOtherApiCh <- "No coins match the filter"
 			return
 		}
 
@@ -132,47 +236,64 @@ func handleApiRequest(w http.ResponseWriter, r *http.Request) {
 
 		nameToCheckInOtherApi = findCoinID(responseData2, nameToCheckInOtherApi)
 
-		priceOfCoinOtherApi := createCoinGeckoPriceRequest(w, nameToCheckInOtherApi, client)
+		priceOfCoinOtherApi := createCoinGeckoPriceRequest(r.Context(), nameToCheckInOtherApi, client)
 
 		priceOfCoinOtherApiCh <- priceOfCoinOtherApi
 	}()
 
 	responseData := <-responseDataCh
-	if responseData.Data == nil {
+	if listingsErr != nil {
+		writeError(w, r, req.Format, listingsErr)
 		return
 	}
 
 	priceOfCoinOtherApi := <-priceOfCoinOtherApiCh
 
-	averageCh := make(chan float64)
-	medianCh := make(chan float64)
-	standardDeviationCh := make(chan float64)
+	writeListings(w, r, req, responseData, fetched, priceOfCoinOtherApi, nil)
+}
 
-	go func() {
-		average := CalculateAverage(responseData)
-		averageCh <- average
-	}()
+// writeListings computes the stats of a listing page and renders it as JSON
+// or through results.html. fetched is the number of rows before filtering,
+// snapshot is set for listings rebuilt from a stored snapshot.
+func writeListings(w http.ResponseWriter, r *http.Request, req listingsRequest, responseData types.Response, fetched int, priceOfCoinOtherApi string, snapshot *types.SnapshotInfo) {
+	var average, median, standardDeviation, max, min float64
+
+	// the page lists every coin, the stats may leave the outliers out
+	var outliers *types.OutlierReport
+	statsData := responseData
+	if req.Outliers != nil {
+		outliers = detectOutliers(responseData.Data, *req.Outliers)
+		if outliers.Policy == "exclude" {
+			statsData.Data = withoutOutliers(responseData.Data, outliers)
+		}
+	}
 
-	go func() {
-		median := CalculateMedian(responseData)
-		medianCh <- median
-	}()
+	// stats are undefined for an empty set, leave them at zero
+	if len(statsData.Data) > 0 {
+		averageCh := make(chan float64)
+		medianCh := make(chan float64)
+		standardDeviationCh := make(chan float64)
 
-	go func() {
-		standardDeviation := CalculateStandardDeviation(responseData)
-		standardDeviationCh <- standardDeviation
-	}()
+		go func() {
+			average := CalculateAverage(statsData)
+			averageCh <- average
+		}()
 
-	average := <-averageCh
-	median := <-medianCh
-	standardDeviation := <-standardDeviationCh
+		go func() {
+			median := CalculateMedian(statsData)
+			medianCh <- median
+		}()
 
-	tmpl, err := template.New("").Parse(templates.Results)
+		go func() {
+			standardDeviation := CalculateStandardDeviation(statsData)
+			standardDeviationCh <- standardDeviation
+		}()
 
-	if err != nil {
-		log.Println("Error parsing template:", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+		average = <-averageCh
+		median = <-medianCh
+		standardDeviation = <-standardDeviationCh
+		max = CalculateMax(statsData)
+		min = CalculateMin(statsData)
 	}
 
 	templateData := types.ResponseToHttp{
@@ -180,64 +301,116 @@ func handleApiRequest(w http.ResponseWriter, r *http.Request) {
 		Average:             average,
 		Median:              median,
 		StandardDeviation:   standardDeviation,
-		Max:                 CalculateMax(responseData),
-		Min:                 CalculateMin(responseData),
+		Max:                 max,
+		Min:                 min,
 		PriceOfCoinOtherApi: priceOfCoinOtherApi,
+		Filter:              req.Query.Filter,
+		Pagination:          newPagination(r.URL.Path, req.Values, req.Query, fetched),
+		Snapshot:            snapshot,
+		Outliers:            outliers,
+		Caller:              types.Caller{APIKey: req.APIKey},
+	}
+	if req.Risk != "" {
+		until := req.At
+		if until.IsZero() {
+			until = time.Now().UTC()
+		}
+		templateData.Risk = listingRisk(responseData.Data, req.Risk, req.RiskFreeRate, until)
 	}
 
-	err = tmpl.Execute(w, templateData)
-
-	if err != nil {
-		log.Println("Error executing template:", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	if req.Format == "json" {
+		writeJSON(w, http.StatusOK, templateData)
 		return
 	}
 
+	renderHTML(w, r, http.StatusOK, templates.Results, templateData)
 }
 
-func createCoinMarketCapRequest(w http.ResponseWriter, r *http.Request, client *http.Client) (types.Response, error) {
-	limit := r.FormValue("limit")
-	order := r.FormValue("order")
-
-	req, err := http.NewRequest("GET", "https://pro-api.coinmarketcap.com/v1/cryptocurrency/listings/latest", nil)
+// createCoinMarketCapRequest fetches one page of the latest listings.
+func createCoinMarketCapRequest(ctx context.Context, client *http.Client, query listingsQuery, start, limit int) (types.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://pro-api.coinmarketcap.com/v1/cryptocurrency/listings/latest", nil)
 	if err != nil {
-		log.Print(err)
-		http.Error(w, "Error creating request to coingecko", http.StatusInternalServerError)
-		return types.Response{}, err
+		return types.Response{}, errInternal(err)
 	}
 
 	q := req.URL.Query()
-	q.Add("sort", order)
-	q.Add("limit", limit)
+	q.Add("sort", query.Order)
+	q.Add("sort_dir", query.SortDir)
+	q.Add("start", strconv.Itoa(start))
+	q.Add("limit", strconv.Itoa(limit))
+	if len(query.Convert) > 0 {
+		q.Add("convert", strings.Join(query.Convert, ","))
+	}
+	addCMCParams(query.Filter, q)
 	req.URL.RawQuery = q.Encode()
 
 	req.Header.Set("Accepts", "application/json")
-	req.Header.Add("X-CMC_PRO_API_KEY", "713a6b7d-6e93-4d59-88ea-038f57de2ae6")
+	req.Header.Add("X-CMC_PRO_API_KEY", cfg.CMCAPIKey)
 
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Print("Error sending request to server: ", err)
-		http.Error(w, "Error sending request to server", http.StatusInternalServerError)
-		return types.Response{}, err
+		return types.Response{}, errUpstreamUnavailable("coinmarketcap", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Received non-OK status code while sending request to coinmarketcap: %d", resp.StatusCode)
-		http.Error(w, "Recieved non-OK status code from coinmarketcap.", resp.StatusCode)
-		return types.Response{}, err
-	}
-
+	// error bodies carry the same status object as successful ones, so the
+	// body is decoded before looking at the HTTP status
 	var responseData types.Response
-	if err := json.NewDecoder(resp.Body).Decode(&responseData); err != nil {
-		log.Print("Error decoding JSON response: ", err)
-		http.Error(w, "Error decoding JSON response", http.StatusInternalServerError)
-		return types.Response{}, err
+	decodeErr := json.NewDecoder(resp.Body).Decode(&responseData)
+
+	if apiErr := cmcError(ctx, resp.StatusCode, responseData.Status); apiErr != nil {
+		return types.Response{}, apiErr
+	}
+	if decodeErr != nil {
+		return types.Response{}, errUpstreamBadResponse("coinmarketcap", decodeErr)
 	}
 
 	return responseData, nil
 }
 
+// templateFuncs are available to every HTML template.
+var templateFuncs = template.FuncMap{
+	"deref": func(f *float64) float64 { return *f },
+	"add": func(nums ...int) int {
+		var sum int
+		for _, n := range nums {
+			sum += n
+		}
+		return sum
+	},
+	"label":  metricLabel,
+	"short":  shortNumber,
+	"signed": signed,
+	"heat":   heatClass,
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error encoding JSON response", "err", err)
+	}
+}
+
+// renderHTML executes page into a buffer first, so a template failure can
+// still become an error page instead of a truncated one.
+func renderHTML(w http.ResponseWriter, r *http.Request, status int, page string, data any) {
+	tmpl, err := template.New("").Funcs(templateFuncs).Parse(page)
+	if err != nil {
+		writeError(w, r, "html", errInternal(err))
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		writeError(w, r, "html", errInternal(err))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
 func contains(slice []string, s string) bool {
 	for _, item := range slice {
 		if item == s {
@@ -247,67 +420,66 @@ func contains(slice []string, s string) bool {
 	return false
 }
 
-func createCoinGeckoRequest(w http.ResponseWriter, client *http.Client) ([]types.Coin, error) {
-	req2, err := http.NewRequest("GET", "https://api.coingecko.com/api/v3/coins/list", nil)
+// createCoinGeckoRequest fetches the full CoinGecko coin list. Errors are
+// only logged and returned; the list is a best-effort lookup table and the
+// caller decides whether a failure matters.
+func createCoinGeckoRequest(ctx context.Context, client *http.Client) ([]types.Coin, error) {
+	req2, err := http.NewRequestWithContext(ctx, "GET", "https://api.coingecko.com/api/v3/coins/list", nil)
 	if err != nil {
-		log.Print(err)
-		http.Error(w, "Error creating request to coingecko", http.StatusInternalServerError)
+		slog.ErrorContext(ctx, "Error creating coingecko request", "err", err)
 		return []types.Coin{}, err
 	}
 
-	req2.Header.Add("x-cg-demo-api-key", "CG-x46kYuMHifPvVb46Qxj8WnRs")
+	req2.Header.Add("x-cg-demo-api-key", cfg.CoinGeckoAPIKey)
 
 	resp2, err := client.Do(req2)
 	if err != nil {
-		log.Print("Error sending request to server: ", err)
-		http.Error(w, "Error sending request to server:", http.StatusInternalServerError)
+		slog.ErrorContext(ctx, "Error sending request to server", "provider", "coingecko", "err", err)
 		return []types.Coin{}, err
 	}
+	defer resp2.Body.Close()
 
 	if resp2.StatusCode != http.StatusOK {
-		log.Printf("Received non-OK status code while sending request to coingecko: %d", resp2.StatusCode)
-		http.Error(w, "Recieved non-OK status code from coinmarketcap.", resp2.StatusCode)
-		return []types.Coin{}, err
+		slog.ErrorContext(ctx, "Received non-OK status code", "provider", "coingecko", "status", resp2.StatusCode)
+		return []types.Coin{}, fmt.Errorf("coingecko returned status %d", resp2.StatusCode)
 	}
 
 	var responseData2 []types.Coin
 	if err := json.NewDecoder(resp2.Body).Decode(&responseData2); err != nil {
-		log.Print("Error decoding JSON response: ", err)
-		http.Error(w, "Error decoding JSON response", http.StatusInternalServerError)
+		slog.ErrorContext(ctx, "Error decoding JSON response", "provider", "coingecko", "err", err)
 		return []types.Coin{}, err
 	}
 
 	return responseData2, nil
 }
 
-func createCoinGeckoPriceRequest(w http.ResponseWriter, coinID string, client *http.Client) string {
-	req3, err := http.NewRequest("GET", "https://api.coingecko.com/api/v3/simple/price?ids="+coinID+"&vs_currencies=usd", nil)
+// createCoinGeckoPriceRequest returns the CoinGecko USD price of coinID for
+// display. The cross-check is informational, so failures are logged and
+// shown as NaN instead of failing the whole response.
+func createCoinGeckoPriceRequest(ctx context.Context, coinID string, client *http.Client) string {
+	req3, err := http.NewRequestWithContext(ctx, "GET", "https://api.coingecko.com/api/v3/simple/price?ids="+coinID+"&vs_currencies=usd", nil)
 	if err != nil {
-		log.Print(err)
-		http.Error(w, "Error creating request", http.StatusInternalServerError)
+		slog.ErrorContext(ctx, "Error creating coingecko request", "err", err)
 		return "NaN"
 	}
 
-	req3.Header.Add("x-cg-demo-api-key", "CG-x46kYuMHifPvVb46Qxj8WnRs")
+	req3.Header.Add("x-cg-demo-api-key", cfg.CoinGeckoAPIKey)
 
 	resp3, err := client.Do(req3)
 	if err != nil {
-		log.Print(err)
-		http.Error(w, "Error sending request to server", http.StatusInternalServerError)
+		slog.ErrorContext(ctx, "Error sending request to server", "provider", "coingecko", "err", err)
 		return "NaN"
 	}
 	defer resp3.Body.Close()
 
 	if resp3.StatusCode != http.StatusOK {
-		log.Printf("Received non-OK status code while sending request to coingecko: %d", resp3.StatusCode)
-		http.Error(w, "Recieved status code from coingecko:", resp3.StatusCode)
+		slog.ErrorContext(ctx, "Received non-OK status code", "provider", "coingecko", "status", resp3.StatusCode)
 		return "NaN"
 	}
 
 	var responseData3 map[string]map[string]float64
 	if err := json.NewDecoder(resp3.Body).Decode(&responseData3); err != nil {
-		log.Print("Error decoding JSON response: ", err)
-		http.Error(w, "Error decoding JSON response", http.StatusInternalServerError)
+		slog.ErrorContext(ctx, "Error decoding JSON response", "provider", "coingecko", "err", err)
 		return "NaN"
 	}
 
@@ -331,71 +503,41 @@ func findCoinID(coins []types.Coin, nameToCheckInOtherApi string) string {
 	return nameToCheckInOtherApi
 }
 
-func CalculateAverage(listings types.Response) float64 {
-	var sum float64
-	for _, listing := range listings.Data {
-		sum += listing.Quote["USD"].Price
-	}
-	return sum / float64(len(listings.Data))
-}
-
-func CalculateMedian(listings types.Response) float64 {
-	prices := make([]float64, len(listings.Data))
-	for i, listing := range listings.Data {
-		prices[i] = listing.Quote["USD"].Price
-	}
-	sort.Float64s(prices)
-
-	middle := len(prices) / 2
-	median := prices[middle]
-	if len(prices)%2 == 0 {
-		median = (median + prices[middle-1]) / 2
+// homeHandler renders the forms with a market widget from the cached
+// listings. The widget is left out when the listings cannot be loaded, the
+// forms still work.
+func homeHandler(rw http.ResponseWriter, r *http.Request) {
+	var data *types.MarketOverview
+	if listings := homeListings(r.Context()); listings != nil {
+		overview := marketOverview(listings, homeMovers, 0)
+		data = &overview
 	}
-	return median
-}
 
-func CalculateStandardDeviation(listings types.Response) float64 {
-	var sum float64
-	average := CalculateAverage(listings)
-	for _, listing := range listings.Data {
-		deviation := listing.Quote["USD"].Price - average
-		sum += deviation * deviation
-	}
-	variance := sum / float64(len(listings.Data))
-	return math.Sqrt(variance)
+	renderHTML(rw, r, http.StatusOK, templates.Index, data)
 }
 
-func CalculateMax(listings types.Response) float64 {
-	var max float64
-	for _, listing := range listings.Data {
-		if listing.Quote["USD"].Price > max {
-			max = listing.Quote["USD"].Price
-		}
+// homeListings are the listings of the market widget: the cached ones, or
+// the newest stored snapshot before anything was fetched. The home page
+// serves every unknown path without an api-key, so it never calls
+// CoinMarketCap itself.
+func homeListings(ctx context.Context) []types.CryptoListing {
+	if listings := latestListings.cached(); listings != nil {
+		return listings
 	}
-	return max
-}
 
-func CalculateMin(listings types.Response) float64 {
-	min := listings.Data[0].Quote["USD"].Price
-	for _, listing := range listings.Data {
-		if listing.Quote["USD"].Price < min {
-			min = listing.Quote["USD"].Price
-		}
+	t, ok := snapshots.latest()
+	if !ok {
+		return nil
 	}
-	return min
-}
-
-func homeHandler(rw http.ResponseWriter, r *http.Request) {
-	tmpl, err := template.New("").Parse(templates.Index)
-
+	snap, err := snapshots.load(t)
 	if err != nil {
-		panic(err)
+		slog.WarnContext(ctx, "Could not load the market widget", "err", err)
+		return nil
 	}
+	return snap.Listings
+}
 
-	var data interface{} = nil
-
-	err = tmpl.Execute(rw, data)
-	if err != nil {
-		panic(err)
-	}
+func docsHandler(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Write([]byte(templates.Docs))
 }
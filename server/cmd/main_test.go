@@ -0,0 +1,18 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+// TestMain loads the configuration the handlers read, from the environment
+// like main does.
+func TestMain(m *testing.M) {
+	var err error
+	if cfg, err = loadConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	templates "server/html"
+	"server/types"
+	"sort"
+)
+
+// marketWindows are the percent change windows of a market overview, in
+// display order.
+var marketWindows = []struct {
+	name   string
+	change func(q types.Quote) float64
+}{
+	{"1h", func(q types.Quote) float64 { return q.PercentChange1h }},
+	{"24h", func(q types.Quote) float64 { return q.PercentChange24h }},
+	{"7d", func(q types.Quote) float64 { return q.PercentChange7d }},
+}
+
+// defaultMovers is the number of gainers and losers per window, homeMovers
+// the number the home page widget shows. At most maxMovers are listed, like
+// in a snapshot diff.
+const (
+	defaultMovers = 5
+	homeMovers    = 3
+)
+
+// marketHandler serves /api/market?top=, an overview of the cached top
+// listings by market cap.
+func marketHandler(w http.ResponseWriter, r *http.Request) {
+	p, format, err := clientParams(r)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	top := p.integer("top", defaultMovers, 1, maxMovers, false)
+	var minVolume float64
+	if v := p.number("volume_24h_min"); v != nil {
+		minVolume = *v
+	}
+	if err := p.err(); err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	if !allowRequest(w, r, format) {
+		return
+	}
+
+	listings, err := latestListings.get(r.Context(), upstreamClient)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	overview := marketOverview(listings, top, minVolume)
+	overview.APIKey = p.str("api-key")
+
+	if format == "json" {
+		writeJSON(w, http.StatusOK, overview)
+		return
+	}
+	renderHTML(w, r, http.StatusOK, templates.Market, overview)
+}
+
+// marketOverview ranks the movers of every window among the listings
+// traded at least minVolume in USD over 24 hours. Breadth is counted over
+// all listings.
+func marketOverview(listings []types.CryptoListing, top int, minVolume float64) types.MarketOverview {
+	m := types.MarketOverview{
+		Coins:     len(listings),
+		Top:       top,
+		MinVolume: minVolume,
+		Windows:   make([]types.MarketWindow, 0, len(marketWindows)),
+	}
+
+	var movers []types.CryptoListing
+	for _, l := range listings {
+		if l.Quote["USD"].Volume24h >= minVolume {
+			movers = append(movers, l)
+		}
+	}
+
+	for _, window := range marketWindows {
+		mw := types.MarketWindow{
+			Window:  window.name,
+			Gainers: []types.MarketMover{},
+			Losers:  []types.MarketMover{},
+		}
+		for _, l := range listings {
+			switch change := window.change(l.Quote["USD"]); {
+			case change > 0:
+				mw.Advancers++
+			case change < 0:
+				mw.Decliners++
+			default:
+				mw.Unchanged++
+			}
+		}
+		if mw.Decliners > 0 {
+			ratio := float64(mw.Advancers) / float64(mw.Decliners)
+			mw.AdvanceDecline = &ratio
+		}
+
+		for _, l := range biggestChanges(movers, window.change, 1) {
+			if len(mw.Gainers) == top || window.change(l.Quote["USD"]) <= 0 {
+				break
+			}
+			mw.Gainers = append(mw.Gainers, marketMover(l, window.change))
+		}
+		for _, l := range biggestChanges(movers, window.change, -1) {
+			if len(mw.Losers) == top || window.change(l.Quote["USD"]) >= 0 {
+				break
+			}
+			mw.Losers = append(mw.Losers, marketMover(l, window.change))
+		}
+		m.Windows = append(m.Windows, mw)
+	}
+
+	var above int
+	for _, l := range listings {
+		if l.Quote["USD"].PercentChange7d > 0 {
+			above++
+		}
+		if l.ID == benchmarkID {
+			dominance := l.Quote["USD"].MarketCapDominance
+			m.BTCDominance = &dominance
+		}
+	}
+	m.AboveWeekAgo = percentOf(float64(above), float64(len(listings)))
+	return m
+}
+
+// biggestChanges returns a copy of listings sorted by change times sign,
+// largest first. Ties keep the market cap rank order.
+func biggestChanges(listings []types.CryptoListing, change func(q types.Quote) float64, sign float64) []types.CryptoListing {
+	sorted := append([]types.CryptoListing{}, listings...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sign*change(sorted[i].Quote["USD"]) > sign*change(sorted[j].Quote["USD"])
+	})
+	return sorted
+}
+
+func marketMover(l types.CryptoListing, change func(q types.Quote) float64) types.MarketMover {
+	q := l.Quote["USD"]
+	return types.MarketMover{
+		ID:            l.ID,
+		Name:          l.Name,
+		Symbol:        l.Symbol,
+		Slug:          l.Slug,
+		Rank:          l.CMCRank,
+		Price:         q.Price,
+		Volume24h:     q.Volume24h,
+		PercentChange: change(q),
+	}
+}
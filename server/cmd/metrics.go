@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The metrics below are exposed at /metrics in the Prometheus text format.
+// There is no client library in the vendor tree, so the handful of types
+// needed here are implemented directly.
+var (
+	httpRequests = newCounterVec("http_requests_total",
+		"HTTP requests served, by route pattern, method and status code.",
+		"route", "method", "status")
+	httpDuration = newHistogramVec("http_request_duration_seconds",
+		"Time spent serving HTTP requests, by route pattern and status code.",
+		defaultBuckets, "route", "status")
+
+	upstreamRequests = newCounterVec("upstream_requests_total",
+		"Calls made to data providers, by provider and status code (error when no response was received).",
+		"provider", "status")
+	upstreamDuration = newHistogramVec("upstream_request_duration_seconds",
+		"Latency of calls made to data providers.",
+		defaultBuckets, "provider")
+	upstreamErrors = newCounterVec("upstream_errors_total",
+		"Calls to data providers that failed or returned a non-2xx status.",
+		"provider")
+
+	cacheRequests = newCounterVec("cache_requests_total",
+		"Cache lookups by cache and result (hit or miss); the hit ratio is hit / (hit + miss).",
+		"cache", "result")
+
+	snapshotsTaken = newCounterVec("snapshots_total",
+		"Listing snapshots attempted by the snapshot poller, by result (ok or error).",
+		"result")
+
+	rateLimitRejections = newCounterVec("rate_limit_rejections_total",
+		"Requests rejected by the per-client rate limiter.")
+
+	_ = newGaugeFunc("rate_limiter_clients",
+		"Number of clients tracked by the rate limiter.",
+		func() float64 {
+			mu.Lock()
+			defer mu.Unlock()
+			return float64(len(clients))
+		})
+	_ = newGaugeFunc("go_goroutines",
+		"Number of goroutines that currently exist.",
+		func() float64 { return float64(runtime.NumGoroutine()) })
+)
+
+var defaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []metric
+)
+
+func register(m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	registry = append(registry, m)
+}
+
+type series struct {
+	labels []string
+	value  float64
+}
+
+type counterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	c := &counterVec{name: name, help: help, labels: labels, series: make(map[string]*series)}
+	if len(labels) == 0 {
+		// a plain counter is exported as 0 before its first increment
+		c.series[""] = &series{}
+	}
+	register(c)
+	return c
+}
+
+func (c *counterVec) inc(values ...string) {
+	c.add(1, values...)
+}
+
+func (c *counterVec) add(v float64, values ...string) {
+	key := strings.Join(values, "\xff")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.series[key]
+	if !ok {
+		s = &series{labels: values}
+		c.series[key] = s
+	}
+	s.value += v
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, s.labels, ""), formatValue(s.value))
+	}
+}
+
+type histogram struct {
+	labels []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogram)}
+	register(h)
+	return h
+}
+
+func (h *histogramVec) observe(v float64, values ...string) {
+	key := strings.Join(values, "\xff")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{labels: values, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		for i, b := range h.buckets {
+			le := `le="` + formatValue(b) + `"`
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.labels, le), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.labels, `le="+Inf"`), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, s.labels, ""), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, s.labels, ""), s.count)
+	}
+}
+
+type gaugeFunc struct {
+	name, help string
+	fn         func() float64
+}
+
+func newGaugeFunc(name, help string, fn func() float64) *gaugeFunc {
+	g := &gaugeFunc{name: name, help: help, fn: fn}
+	register(g)
+	return g
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name, formatValue(g.fn()))
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatLabels(names, values []string, extra string) string {
+	if len(names) == 0 && extra == "" {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(values[i]))
+		b.WriteByte('"')
+	}
+	if extra != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(extra)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	registryMu.Lock()
+	metrics := make([]metric, len(registry))
+	copy(metrics, registry)
+	registryMu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// withMetrics records request count and latency per route. The route is the
+// mux pattern that matched, not the raw path, to keep the label set bounded.
+func withMetrics(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		_, route := mux.Handler(r)
+
+		mux.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		status := strconv.Itoa(rec.status)
+		httpRequests.inc(route, r.Method, status)
+		httpDuration.observe(time.Since(start).Seconds(), route, status)
+	})
+}
@@ -0,0 +1,1037 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"server/types"
+	"strings"
+	"time"
+)
+
+// registeredRoutes collects every pattern passed to handle, so the tests can
+// check the OpenAPI document against what the mux actually serves.
+var registeredRoutes []string
+
+func handle(mux *http.ServeMux, pattern string, handler http.HandlerFunc) {
+	registeredRoutes = append(registeredRoutes, pattern)
+	mux.HandleFunc(pattern, handler)
+}
+
+// routePattern maps a templated spec path to the prefix pattern the mux
+// serves it under, /api/coins/{coin} to /api/coins/.
+func routePattern(path string) string {
+	if i := strings.Index(path, "{"); i >= 0 {
+		return path[:i]
+	}
+	return path
+}
+
+// schemas turns Go types into OpenAPI component schemas using their json
+// tags, so response schemas follow the types package automatically.
+type schemas map[string]any
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (s schemas) ref(v any) map[string]any {
+	return s.schema(reflect.TypeOf(v))
+}
+
+func (s schemas) schema(t reflect.Type) map[string]any {
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		inner := s.schema(t.Elem())
+		if _, isRef := inner["$ref"]; isRef {
+			return map[string]any{"allOf": []any{inner}, "nullable": true}
+		}
+		inner["nullable"] = true
+		return inner
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number", "format": "double"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.Struct:
+		name := schemaName(t)
+		if _, ok := s[name]; !ok {
+			// reserve the name first so recursive types terminate
+			s[name] = nil
+			s[name] = s.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+func (s schemas) object(t reflect.Type) map[string]any {
+	props := make(map[string]any)
+	var required []string
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name := f.Name
+		omitempty := false
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			parts := strings.Split(tag, ",")
+			if parts[0] == "-" {
+				continue
+			}
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			for _, opt := range parts[1:] {
+				if opt == "omitempty" {
+					omitempty = true
+				}
+			}
+		}
+
+		// untagged embedded structs are flattened, as encoding/json does
+		if f.Anonymous && name == f.Name && f.Type.Kind() == reflect.Struct {
+			inner := s.object(f.Type)
+			for k, v := range inner["properties"].(map[string]any) {
+				props[k] = v
+			}
+			if req, ok := inner["required"].([]string); ok {
+				required = append(required, req...)
+			}
+			continue
+		}
+
+		props[name] = s.schema(f.Type)
+		if !omitempty && f.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+
+	obj := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		obj["required"] = required
+	}
+	return obj
+}
+
+func schemaName(t reflect.Type) string {
+	name := t.Name()
+	if name == "" {
+		return "Anonymous"
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+func jsonContent(schema map[string]any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+func textResponse(description, contentType string) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content":     map[string]any{contentType: map[string]any{"schema": map[string]any{"type": "string"}}},
+	}
+}
+
+// errorResponse is an RFC 7807 problem for API clients, or the HTML error
+// page for browsers.
+func errorResponse(s schemas, description string) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content": map[string]any{
+			"application/problem+json": map[string]any{"schema": s.ref(problem{})},
+			"text/html":                map[string]any{"schema": map[string]any{"type": "string"}},
+		},
+	}
+}
+
+func listingsParameters() []any {
+	return []any{
+		apiKeyParameter(),
+		map[string]any{
+			"name": "limit", "in": "query", "required": true,
+			"description": "Number of listings to return. Limits above the CoinMarketCap page size are fetched in several upstream calls.",
+			"schema":      map[string]any{"type": "integer", "minimum": 1, "maximum": cfg.MaxListingLimit},
+		},
+		map[string]any{
+			"name": "start", "in": "query",
+			"description": "1-based offset of the first listing; the response links to the next and previous pages.",
+			"schema":      map[string]any{"type": "integer", "minimum": 1, "default": 1},
+		},
+		map[string]any{
+			"name": "sort_dir", "in": "query",
+			"description": "Sort direction.",
+			"schema":      map[string]any{"type": "string", "enum": sortDirOptions, "default": "desc"},
+		},
+		map[string]any{
+			"name": "order", "in": "query", "required": true,
+			"description": "Field the listings are sorted by.",
+			"schema":      map[string]any{"type": "string", "enum": orderOptions},
+		},
+		map[string]any{
+			"name": "tags", "in": "query",
+			"description": "Comma separated tags a listing must all carry.",
+			"schema":      map[string]any{"type": "string"},
+		},
+		map[string]any{
+			"name": "exclude_tags", "in": "query",
+			"description": "Comma separated tags a listing must not carry.",
+			"schema":      map[string]any{"type": "string"},
+		},
+		map[string]any{
+			"name": "platform", "in": "query",
+			"description": "Comma separated platform slugs (for example ethereum); native coins have no platform and never match.",
+			"schema":      map[string]any{"type": "string"},
+		},
+		numberParameter("price_min", "Minimum USD price."),
+		numberParameter("price_max", "Maximum USD price."),
+		numberParameter("market_cap_min", "Minimum USD market cap."),
+		numberParameter("market_cap_max", "Maximum USD market cap."),
+		numberParameter("volume_24h_min", "Minimum USD 24h volume."),
+		timestampParameter("at", "Rebuild the listing from the stored snapshot nearest to this time instead of fetching it live; the snapshot field says which one was used. Snapshots keep only the top SNAPSHOT_LIMIT coins by market cap, so with at the order must be market_cap, sort_dir desc and the page within those coins.", false),
+		map[string]any{
+			"name": "risk", "in": "query",
+			"description": fmt.Sprintf("Add the risk metrics of the listed coins from the USD candles of this interval, over the %d candles up to now or to at.", defaultCandles),
+			"schema":      map[string]any{"type": "string", "enum": candleIntervalNames()},
+		},
+		riskFreeParameter(),
+		map[string]any{
+			"name": "outliers", "in": "query",
+			"description": "Flag the listed coins whose outlier_metric lies outside the fences of this test: zscore (mean and standard deviations), mad (median and scaled median absolute deviations) or iqr (Tukey's fences).",
+			"schema":      map[string]any{"type": "string", "enum": outlierMethodNames()},
+		},
+		map[string]any{
+			"name": "outlier_metric", "in": "query",
+			"description": "USD field the outliers are detected in.",
+			"schema":      map[string]any{"type": "string", "enum": outlierMetricNames(), "default": "price"},
+		},
+		map[string]any{
+			"name": "outlier_threshold", "in": "query",
+			"description": "Width of the fences: standard deviations for zscore (default 3), modified z-score for mad (default 3.5), interquartile ranges for iqr (default 1.5).",
+			"schema":      map[string]any{"type": "number", "minimum": 0, "exclusiveMinimum": true},
+		},
+		map[string]any{
+			"name": "outlier_policy", "in": "query",
+			"description": "exclude computes the price stats without the outliers and needs outlier_metric price, flag only reports them. The response says which was applied.",
+			"schema":      map[string]any{"type": "string", "enum": outlierPolicies, "default": "flag"},
+		},
+		formatParameter(),
+	}
+}
+
+func coinParameters() []any {
+	return []any{
+		apiKeyParameter(),
+		map[string]any{
+			"name": "convert", "in": "query",
+			"description": fmt.Sprintf("Comma separated currency codes to quote the coin in, at most %d.", maxConvert),
+			"schema":      map[string]any{"type": "string", "default": "USD"},
+		},
+		formatParameter(),
+	}
+}
+
+func apiKeyParameter() map[string]any {
+	return map[string]any{
+		"name": "api-key", "in": "query", "required": true,
+		"description": "Key issued to the client.",
+		"schema":      map[string]any{"type": "string"},
+	}
+}
+
+func formatParameter() map[string]any {
+	return map[string]any{
+		"name": "format", "in": "query",
+		"description": "Response format; JSON is also chosen by an Accept: application/json header.",
+		"schema":      map[string]any{"type": "string", "enum": formatOptions},
+	}
+}
+
+func holdingParameters() []any {
+	return []any{
+		apiKeyParameter(),
+		map[string]any{
+			"name": "coin", "in": "query", "required": true,
+			"description": "CoinMarketCap ID, slug or symbol; the coin must be among the cached top listings.",
+			"schema":      map[string]any{"type": "string"},
+		},
+		map[string]any{
+			"name": "quantity", "in": "query", "required": true,
+			"description": "Number of coins held.",
+			"schema":      map[string]any{"type": "number", "exclusiveMinimum": true, "minimum": 0},
+		},
+		map[string]any{
+			"name": "cost_basis", "in": "query", "required": true,
+			"description": "Total USD paid for the quantity, not the price per coin.",
+			"schema":      map[string]any{"type": "number", "minimum": 0},
+		},
+		map[string]any{
+			"name": "acquired", "in": "query",
+			"description": "Day the coins were acquired; today when absent.",
+			"schema":      map[string]any{"type": "string", "format": "date"},
+		},
+		formatParameter(),
+	}
+}
+
+func indexParameters() []any {
+	return []any{
+		apiKeyParameter(),
+		map[string]any{
+			"name": "name", "in": "query", "required": true,
+			"description": "Name of the index.",
+			"schema":      map[string]any{"type": "string", "maxLength": maxIndexName},
+		},
+		map[string]any{
+			"name": "method", "in": "query",
+			"description": "top holds the largest coins by market cap, fixed holds the coins of weights.",
+			"schema":      map[string]any{"type": "string", "enum": indexMethods, "default": "top"},
+		},
+		map[string]any{
+			"name": "top", "in": "query",
+			"description": "Number of coins of a top index.",
+			"schema":      map[string]any{"type": "integer", "minimum": 1, "maximum": maxIndexConstituents, "default": 10},
+		},
+		map[string]any{
+			"name": "weighting", "in": "query",
+			"description": "How a top index weighs its coins.",
+			"schema":      map[string]any{"type": "string", "enum": indexWeightings, "default": "market_cap"},
+		},
+		map[string]any{
+			"name": "exclude_tags", "in": "query",
+			"description": "Comma separated tags a coin of a top index must not carry, for example stablecoin.",
+			"schema":      map[string]any{"type": "string"},
+		},
+		map[string]any{
+			"name": "weights", "in": "query",
+			"description": "Comma separated coin:weight pairs of a fixed index, for example BTC:60,ETH:40; weights are relative. Coins are resolved against the newest snapshot.",
+			"schema":      map[string]any{"type": "string"},
+		},
+		map[string]any{
+			"name": "cap", "in": "query",
+			"description": "Highest weight of one coin in percent; the excess is spread over the others.",
+			"schema":      map[string]any{"type": "number", "exclusiveMinimum": true, "minimum": 0, "maximum": 100},
+		},
+		map[string]any{
+			"name": "rebalance", "in": "query",
+			"description": "When the weights are restored, and a top index picks its coins again: midnight UTC, Monday midnight or the first of the month.",
+			"schema":      map[string]any{"type": "string", "enum": rebalanceOptions, "default": "monthly"},
+		},
+		formatParameter(),
+	}
+}
+
+func methodParameter() map[string]any {
+	return map[string]any{
+		"name": "method", "in": "query",
+		"description": "Lot matching: first in first out, last in first out, or average cost.",
+		"schema":      map[string]any{"type": "string", "enum": costMethods, "default": "fifo"},
+	}
+}
+
+func importParameters() []any {
+	return []any{
+		apiKeyParameter(),
+		map[string]any{
+			"name": "layout", "in": "query",
+			"description": "CSV layout; auto picks the first layout whose header row is found. generic has the columns date, type (buy, sell, transfer_in, transfer_out), coin, quantity, price and an optional fee, amounts in USD.",
+			"schema":      map[string]any{"type": "string", "enum": layoutNames(), "default": "auto"},
+		},
+		map[string]any{
+			"name": "csv", "in": "query",
+			"description": "The CSV text, when it is not uploaded as a file or sent as a text/csv body.",
+			"schema":      map[string]any{"type": "string"},
+		},
+		methodParameter(),
+		formatParameter(),
+	}
+}
+
+func numberParameter(name, description string) map[string]any {
+	return map[string]any{
+		"name": name, "in": "query",
+		"description": description,
+		"schema":      map[string]any{"type": "number", "minimum": 0},
+	}
+}
+
+func riskFreeParameter() map[string]any {
+	return map[string]any{
+		"name": "risk_free", "in": "query",
+		"description": "Yearly risk-free return as a fraction, e.g. 0.04; the server default when absent.",
+		"schema":      map[string]any{"type": "number", "minimum": 0, "maximum": 1, "exclusiveMaximum": true},
+	}
+}
+
+func windowParameter(name string, def int, description string) map[string]any {
+	return map[string]any{
+		"name": name, "in": "query",
+		"description": description,
+		"schema":      map[string]any{"type": "integer", "minimum": 2, "maximum": maxIndicatorWindow, "default": def},
+	}
+}
+
+func timestampParameter(name, description string, required bool) map[string]any {
+	return map[string]any{
+		"name": name, "in": "query", "required": required,
+		"description": description + " Unix seconds, from 1973 on, or RFC 3339; times without a zone are UTC.",
+		"schema":      map[string]any{"type": "string", "example": "2024-01-31T12:00:00Z"},
+	}
+}
+
+// buildSpec returns the OpenAPI 3 document for every route registered in
+// main.
+func buildSpec() map[string]any {
+	s := schemas{}
+
+	listings := map[string]any{
+		"summary":    "Latest listings with price statistics",
+		"parameters": listingsParameters(),
+		"responses": map[string]any{
+			"200": map[string]any{
+				"description": "Listings and statistics, as the results page or as JSON.",
+				"content": map[string]any{
+					"text/html":        map[string]any{"schema": map[string]any{"type": "string"}},
+					"application/json": map[string]any{"schema": s.ref(types.ResponseToHttp{})},
+				},
+			},
+			"400": errorResponse(s, "One or more invalid parameters; every invalid field is listed."),
+			"401": errorResponse(s, "Missing or invalid api-key."),
+			"404": errorResponse(s, "at was given but no snapshot is stored yet."),
+			"429": errorResponse(s, "Rate limit exceeded; see Retry-After."),
+			"500": errorResponse(s, "Internal failure."),
+			"502": errorResponse(s, "A data provider failed; provider and upstream_status say which and how."),
+			"503": errorResponse(s, "A data provider usage limit was reached; see Retry-After."),
+		},
+	}
+	postListings := copyOperation(listings)
+	postListings["requestBody"] = map[string]any{
+		"description": "The same parameters may be sent as a form, as the home page does, or as a JSON object.",
+		"content": map[string]any{
+			"application/x-www-form-urlencoded": map[string]any{"schema": formSchema(listingsParameters())},
+			"application/json":                  map[string]any{"schema": formSchema(listingsParameters())},
+		},
+	}
+
+	coin := map[string]any{
+		"summary": "One coin with quotes in the requested currencies",
+		"parameters": append([]any{
+			map[string]any{
+				"name": "coin", "in": "path", "required": true,
+				"description": "CoinMarketCap ID, slug or symbol, for example 1, bitcoin or BTC. Slugs are tried before symbols.",
+				"schema":      map[string]any{"type": "string"},
+			},
+		}, coinParameters()...),
+		"responses": map[string]any{
+			"200": map[string]any{
+				"description": "The coin, as the detail page or as JSON. history holds its hourly USD candles of the last 7 days when stored snapshots have them.",
+				"content": map[string]any{
+					"text/html":        map[string]any{"schema": map[string]any{"type": "string"}},
+					"application/json": map[string]any{"schema": s.ref(types.CoinDetail{})},
+				},
+			},
+			"400": errorResponse(s, "One or more invalid parameters; every invalid field is listed."),
+			"401": errorResponse(s, "Missing or invalid api-key."),
+			"404": errorResponse(s, "No coin with this ID, slug or symbol."),
+			"429": errorResponse(s, "Rate limit exceeded; see Retry-After."),
+			"500": errorResponse(s, "Internal failure."),
+			"502": errorResponse(s, "A data provider failed; provider and upstream_status say which and how."),
+			"503": errorResponse(s, "A data provider usage limit was reached; see Retry-After."),
+		},
+	}
+	postCoin := copyOperation(coin)
+	postCoin["requestBody"] = map[string]any{
+		"description": "The query parameters may be sent as a form, as the results page does, or as a JSON object.",
+		"content": map[string]any{
+			"application/x-www-form-urlencoded": map[string]any{"schema": formSchema(coinParameters())},
+			"application/json":                  map[string]any{"schema": formSchema(coinParameters())},
+		},
+	}
+
+	clientErrors := func(responses map[string]any) map[string]any {
+		responses["400"] = errorResponse(s, "One or more invalid parameters; every invalid field is listed.")
+		responses["401"] = errorResponse(s, "Missing or invalid api-key.")
+		responses["429"] = errorResponse(s, "Rate limit exceeded; see Retry-After.")
+		responses["500"] = errorResponse(s, "Internal failure.")
+		responses["502"] = errorResponse(s, "A data provider failed; provider and upstream_status say which and how.")
+		responses["503"] = errorResponse(s, "A data provider usage limit was reached; see Retry-After.")
+		return responses
+	}
+	pageOrJSON := func(description string, v any) map[string]any {
+		return map[string]any{
+			"description": description,
+			"content": map[string]any{
+				"text/html":        map[string]any{"schema": map[string]any{"type": "string"}},
+				"application/json": map[string]any{"schema": s.ref(v)},
+			},
+		}
+	}
+	formBody := func(params []any) map[string]any {
+		return map[string]any{
+			"description": "The parameters may also be sent as a form or as a JSON object.",
+			"content": map[string]any{
+				"application/x-www-form-urlencoded": map[string]any{"schema": formSchema(params)},
+				"application/json":                  map[string]any{"schema": formSchema(params)},
+			},
+		}
+	}
+
+	portfolio := map[string]any{
+		"summary":    "The caller's holdings valued at the latest quotes",
+		"parameters": []any{apiKeyParameter(), formatParameter()},
+		"responses": clientErrors(map[string]any{
+			"200": pageOrJSON("Valued portfolio, largest position first; holdings without a quote are left out of the totals.", types.PortfolioValue{}),
+		}),
+	}
+	postPortfolio := copyOperation(portfolio)
+	postPortfolio["requestBody"] = formBody([]any{apiKeyParameter(), formatParameter()})
+
+	holdingPath := map[string]any{
+		"name": "holding", "in": "path", "required": true,
+		"description": "Holding ID as returned when it was added.",
+		"schema":      map[string]any{"type": "string"},
+	}
+	holding := map[string]any{
+		"summary":    "One holding valued at the latest quote",
+		"parameters": []any{holdingPath, apiKeyParameter(), formatParameter()},
+		"responses": clientErrors(map[string]any{
+			"200": pageOrJSON("The valued holding.", types.HoldingValue{}),
+			"404": errorResponse(s, "No such holding in the caller's portfolio."),
+		}),
+	}
+	postHolding := copyOperation(holding)
+	postHolding["requestBody"] = formBody([]any{apiKeyParameter(), formatParameter()})
+
+	pnl := map[string]any{
+		"summary":    "Realized and unrealized P&L of the imported transactions",
+		"parameters": []any{apiKeyParameter(), methodParameter(), formatParameter()},
+		"responses": clientErrors(map[string]any{
+			"200": pageOrJSON("Positions, realized gains per year and the transactions in time order.", types.PnLReport{}),
+		}),
+	}
+	postPnL := copyOperation(pnl)
+	postPnL["requestBody"] = formBody([]any{apiKeyParameter(), methodParameter(), formatParameter()})
+
+	importBody := formBody(importParameters())
+	importBody["description"] = "The CSV as the file field of a multipart form, as a text/csv body, or as the csv field of a form or JSON body."
+	importBody["content"].(map[string]any)["text/csv"] = map[string]any{"schema": map[string]any{"type": "string"}}
+	importBody["content"].(map[string]any)["multipart/form-data"] = map[string]any{"schema": map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"file":    map[string]any{"type": "string", "format": "binary"},
+			"api-key": map[string]any{"type": "string"},
+			"layout":  map[string]any{"type": "string", "enum": layoutNames()},
+		},
+		"required": []string{"file", "api-key"},
+	}}
+
+	compareParameters := []any{
+		apiKeyParameter(),
+		map[string]any{
+			"name": "coins", "in": "query", "required": true,
+			"description": fmt.Sprintf("Comma separated CoinMarketCap IDs, slugs or symbols, 2 to %d, among the cached top listings.", maxCompare),
+			"schema":      map[string]any{"type": "string"},
+		},
+		formatParameter(),
+	}
+	compare := map[string]any{
+		"summary":    "Side by side metrics of several coins",
+		"parameters": compareParameters,
+		"responses": clientErrors(map[string]any{
+			"200": pageOrJSON("Every metric with one value and rank per coin, and the ratio of every later coin to every earlier one.", types.Comparison{}),
+		}),
+	}
+	postCompare := copyOperation(compare)
+	postCompare["requestBody"] = formBody(compareParameters)
+
+	searchParameters := []any{
+		apiKeyParameter(),
+		map[string]any{
+			"name": "q", "in": "query", "required": true,
+			"description": fmt.Sprintf("Name, symbol or slug to look for, at most %d characters. Queries of 4 or more characters tolerate a typo, of 8 or more two.", maxSearchQuery),
+			"schema":      map[string]any{"type": "string"},
+		},
+		map[string]any{
+			"name": "mode", "in": "query",
+			"description": "autocomplete only matches prefixes and always answers with JSON suggestions.",
+			"schema":      map[string]any{"type": "string", "enum": searchModes, "default": "search"},
+		},
+		map[string]any{
+			"name": "limit", "in": "query",
+			"description": "Number of results; 10 by default, 8 in autocomplete mode.",
+			"schema":      map[string]any{"type": "integer", "minimum": 1, "maximum": maxSearchLimit},
+		},
+		formatParameter(),
+	}
+	searchOK := pageOrJSON("Matches across the CoinMarketCap map and the CoinGecko list, best match first and then by market cap rank.", types.SearchResult{})
+	searchOK["content"].(map[string]any)["application/json"] = map[string]any{
+		"schema": map[string]any{"oneOf": []any{s.ref(types.SearchResult{}), s.ref(types.Suggestions{})}},
+	}
+	search := map[string]any{
+		"summary":    "Fuzzy search for coins",
+		"parameters": searchParameters,
+		"responses":  clientErrors(map[string]any{"200": searchOK}),
+	}
+	postSearch := copyOperation(search)
+	postSearch["requestBody"] = formBody(searchParameters)
+
+	diffParameters := []any{
+		apiKeyParameter(),
+		timestampParameter("from", "The earlier snapshot is the one stored nearest to this time.", true),
+		timestampParameter("to", "The later snapshot is the one stored nearest to this time, the newest one when absent.", false),
+		map[string]any{
+			"name": "top", "in": "query",
+			"description": "Compare the coins ranked this high or better in either snapshot.",
+			"schema":      map[string]any{"type": "integer", "minimum": 1, "maximum": cfg.SnapshotLimit, "default": min(100, cfg.SnapshotLimit)},
+		},
+		map[string]any{
+			"name": "movers", "in": "query",
+			"description": "Length of each biggest movers list.",
+			"schema":      map[string]any{"type": "integer", "minimum": 1, "maximum": maxMovers, "default": 5},
+		},
+		formatParameter(),
+	}
+	diff := map[string]any{
+		"summary":    "Changes in the top coins between two stored snapshots",
+		"parameters": diffParameters,
+		"responses": clientErrors(map[string]any{
+			"200": pageOrJSON("Rank movements, entrants, dropouts, price and market cap changes and tag changes; the HTML report leads with the biggest movers.", types.SnapshotDiff{}),
+			"404": errorResponse(s, "No snapshot is stored yet."),
+		}),
+	}
+	postDiff := copyOperation(diff)
+	postDiff["requestBody"] = formBody(diffParameters)
+
+	candlesParameters := []any{
+		apiKeyParameter(),
+		map[string]any{
+			"name": "coin", "in": "query", "required": true,
+			"description": "CoinMarketCap ID, slug or symbol, resolved against the newest snapshot.",
+			"schema":      map[string]any{"type": "string"},
+		},
+		map[string]any{
+			"name": "interval", "in": "query",
+			"description": "Candle resolution.",
+			"schema":      map[string]any{"type": "string", "enum": candleIntervalNames(), "default": "1h"},
+		},
+		map[string]any{
+			"name": "currency", "in": "query",
+			"description": "Quote currency, one of the currencies snapshots are taken in.",
+			"schema":      map[string]any{"type": "string", "enum": cfg.SnapshotCurrencies, "default": "USD"},
+		},
+		timestampParameter("from", fmt.Sprintf("Start of the range, %d candles before to when absent; at most %d candles are returned.", defaultCandles, maxCandles), false),
+		timestampParameter("to", "End of the range, now when absent; the candle containing it is included.", false),
+		map[string]any{
+			"name": "fill", "in": "query",
+			"description": "previous fills missing candles with the close before them; gaps are listed either way.",
+			"schema":      map[string]any{"type": "string", "enum": fillOptions, "default": "none"},
+		},
+		formatParameter(),
+	}
+	candlesOp := map[string]any{
+		"summary":    "OHLCV candles aggregated from the stored snapshots",
+		"parameters": candlesParameters,
+		"responses": clientErrors(map[string]any{
+			"200": pageOrJSON("Candles oldest first and the runs of missing candles.", types.CandleSeries{}),
+			"404": errorResponse(s, "No snapshot is stored yet or the coin is not in the newest one."),
+		}),
+	}
+	postCandles := copyOperation(candlesOp)
+	postCandles["requestBody"] = formBody(candlesParameters)
+
+	indicatorsParameters := []any{
+		apiKeyParameter(),
+		candlesParameters[1],
+		candlesParameters[2],
+		candlesParameters[3],
+		candlesParameters[4],
+		candlesParameters[5],
+		map[string]any{
+			"name": "indicators", "in": "query",
+			"description": "Comma-separated indicators to compute, all when absent.",
+			"schema":      map[string]any{"type": "string", "example": strings.Join(indicatorNames, ",")},
+		},
+		windowParameter("sma", 20, "Simple moving average window in candles."),
+		windowParameter("ema", 20, "Exponential moving average window in candles."),
+		windowParameter("rsi", 14, "Relative strength index window in candles."),
+		windowParameter("macd_fast", 12, "Fast MACD average, less than macd_slow."),
+		windowParameter("macd_slow", 26, "Slow MACD average."),
+		windowParameter("macd_signal", 9, "MACD signal line average."),
+		windowParameter("bollinger", 20, "Bollinger band window in candles."),
+		numberParameter("bollinger_k", "Bollinger band width in standard deviations, 2 when absent."),
+		windowParameter("atr", 14, "Average true range window in candles."),
+		formatParameter(),
+	}
+	indicators := map[string]any{
+		"summary":    "Technical indicators over the stored candles",
+		"parameters": indicatorsParameters,
+		"responses": clientErrors(map[string]any{
+			"200": pageOrJSON("Indicator values per candle, oldest first.", types.IndicatorSeries{}),
+			"404": errorResponse(s, "No snapshot is stored yet or the coin is not in the newest one."),
+		}),
+	}
+	postIndicators := copyOperation(indicators)
+	postIndicators["requestBody"] = formBody(indicatorsParameters)
+
+	riskParameters := []any{
+		apiKeyParameter(),
+		candlesParameters[1],
+		map[string]any{
+			"name": "interval", "in": "query",
+			"description": "Candle resolution the returns are taken over.",
+			"schema":      map[string]any{"type": "string", "enum": candleIntervalNames(), "default": "1d"},
+		},
+		candlesParameters[3],
+		candlesParameters[4],
+		candlesParameters[5],
+		windowParameter("window", defaultRiskWindow, "Rolling volatility window in returns."),
+		riskFreeParameter(),
+		formatParameter(),
+	}
+	risk := map[string]any{
+		"summary":    "Volatility, drawdown, Sharpe, Sortino and beta against BTC from the stored candles",
+		"parameters": riskParameters,
+		"responses": clientErrors(map[string]any{
+			"200": pageOrJSON("Risk metrics over the range and the rolling volatility per candle.", types.RiskReport{}),
+			"404": errorResponse(s, "No snapshot is stored yet or the coin is not in the newest one."),
+		}),
+	}
+	postRisk := copyOperation(risk)
+	postRisk["requestBody"] = formBody(riskParameters)
+
+	correlationParameters := []any{
+		apiKeyParameter(),
+		map[string]any{
+			"name": "coins", "in": "query", "required": true,
+			"description": fmt.Sprintf("Comma separated CoinMarketCap IDs, slugs or symbols, 2 to %d, resolved against the newest snapshot.", maxCompare),
+			"schema":      map[string]any{"type": "string"},
+		},
+		map[string]any{
+			"name": "window", "in": "query",
+			"description": "Number of returns, ending with the candle containing to.",
+			"schema":      map[string]any{"type": "integer", "minimum": minCorrelationReturns, "maximum": maxCandles - 1, "default": defaultRiskWindow},
+		},
+		riskParameters[2],
+		candlesParameters[3],
+		timestampParameter("to", "End of the window, now when absent.", false),
+		formatParameter(),
+	}
+	correlation := map[string]any{
+		"summary":    "Pearson and Spearman correlation of candle returns between coins",
+		"parameters": correlationParameters,
+		"responses": clientErrors(map[string]any{
+			"200": pageOrJSON("Correlation matrices with the number of shared returns behind every cell; the HTML page draws them as heatmaps.", types.CorrelationMatrix{}),
+			"404": errorResponse(s, "No snapshot is stored yet."),
+		}),
+	}
+	postCorrelation := copyOperation(correlation)
+	postCorrelation["requestBody"] = formBody(correlationParameters)
+
+	marketParameters := []any{
+		apiKeyParameter(),
+		map[string]any{
+			"name": "top", "in": "query",
+			"description": "Gainers and losers per window.",
+			"schema":      map[string]any{"type": "integer", "minimum": 1, "maximum": maxMovers, "default": defaultMovers},
+		},
+		numberParameter("volume_24h_min", "Least USD volume over 24 hours of a gainer or loser; breadth counts every coin."),
+		formatParameter(),
+	}
+	market := map[string]any{
+		"summary":    "Top gainers, losers and market breadth over 1h, 24h and 7d",
+		"parameters": marketParameters,
+		"responses": clientErrors(map[string]any{
+			"200": pageOrJSON("Movers and advancers versus decliners per window among the cached top listings, the share of coins above their 7d level and BTC dominance.", types.MarketOverview{}),
+		}),
+	}
+	postMarket := copyOperation(market)
+	postMarket["requestBody"] = formBody(marketParameters)
+
+	stablecoins := map[string]any{
+		"summary":    "Peg health of every stablecoin on CoinMarketCap and CoinGecko",
+		"parameters": []any{apiKeyParameter(), formatParameter()},
+		"responses": clientErrors(map[string]any{
+			"200": pageOrJSON("Stablecoins as of the newest check, largest deviation first, and the newest alerts. Stablecoins are checked with every snapshot.", types.PegMonitor{}),
+		}),
+	}
+	postStablecoins := copyOperation(stablecoins)
+	postStablecoins["requestBody"] = formBody([]any{apiKeyParameter(), formatParameter()})
+
+	stablecoinParams := []any{
+		map[string]any{
+			"name": "coin", "in": "path", "required": true,
+			"description": "CoinMarketCap ID, slug or symbol of a tracked stablecoin.",
+			"schema":      map[string]any{"type": "string"},
+		},
+		apiKeyParameter(),
+		timestampParameter("from", "Oldest check to return.", false),
+		timestampParameter("to", "Newest check to return.", false),
+		formatParameter(),
+	}
+	stablecoin := map[string]any{
+		"summary":    "One stablecoin with its deviation from the peg at every check",
+		"parameters": stablecoinParams,
+		"responses": clientErrors(map[string]any{
+			"200": pageOrJSON(fmt.Sprintf("The stablecoin; history has one point per snapshot, at most the newest %d in the range.", maxCandles), types.Stablecoin{}),
+			"404": errorResponse(s, "The coin is not a tracked stablecoin."),
+		}),
+	}
+	postStablecoin := copyOperation(stablecoin)
+	postStablecoin["requestBody"] = formBody(stablecoinParams[1:])
+
+	indexList := map[string]any{
+		"summary":    "The caller's custom indices at their latest NAV",
+		"parameters": []any{apiKeyParameter(), formatParameter()},
+		"responses": clientErrors(map[string]any{
+			"200": pageOrJSON("Indices in the order they were created, without history.", types.IndexList{}),
+		}),
+	}
+
+	indexPath := map[string]any{
+		"name": "index", "in": "path", "required": true,
+		"description": "Index ID as returned when it was created.",
+		"schema":      map[string]any{"type": "string"},
+	}
+	indexParams := []any{
+		indexPath,
+		apiKeyParameter(),
+		timestampParameter("from", "Oldest history point to return.", false),
+		timestampParameter("to", "Newest history point to return.", false),
+		formatParameter(),
+	}
+	index := map[string]any{
+		"summary":    "One custom index with its constituents and NAV history",
+		"parameters": indexParams,
+		"responses": clientErrors(map[string]any{
+			"200": pageOrJSON(fmt.Sprintf("The index; history has one point per snapshot, at most the newest %d in the range.", maxCandles), types.Index{}),
+			"404": errorResponse(s, "No such index among the caller's."),
+		}),
+	}
+	postIndex := copyOperation(index)
+	postIndex["requestBody"] = formBody(indexParams[1:])
+
+	paths := map[string]any{
+		"/": map[string]any{
+			"get": map[string]any{
+				"summary":   "Home page with the forms and a market widget",
+				"responses": map[string]any{"200": textResponse("HTML page.", "text/html")},
+			},
+		},
+		"/api/get-listings": map[string]any{
+			"get":  listings,
+			"post": postListings,
+		},
+		"/api/coins/{coin}": map[string]any{
+			"get":  coin,
+			"post": postCoin,
+		},
+		"/api/compare": map[string]any{
+			"get":  compare,
+			"post": postCompare,
+		},
+		"/api/search": map[string]any{
+			"get":  search,
+			"post": postSearch,
+		},
+		"/api/diff": map[string]any{
+			"get":  diff,
+			"post": postDiff,
+		},
+		"/api/candles": map[string]any{
+			"get":  candlesOp,
+			"post": postCandles,
+		},
+		"/api/indicators": map[string]any{
+			"get":  indicators,
+			"post": postIndicators,
+		},
+		"/api/risk": map[string]any{
+			"get":  risk,
+			"post": postRisk,
+		},
+		"/api/correlation": map[string]any{
+			"get":  correlation,
+			"post": postCorrelation,
+		},
+		"/api/market": map[string]any{
+			"get":  market,
+			"post": postMarket,
+		},
+		"/api/stablecoins": map[string]any{
+			"get":  stablecoins,
+			"post": postStablecoins,
+		},
+		"/api/stablecoins/{coin}": map[string]any{
+			"get":  stablecoin,
+			"post": postStablecoin,
+		},
+		"/api/indices": map[string]any{
+			"get": indexList,
+			"post": map[string]any{
+				"summary":     "Create a custom index, invested at the newest snapshot and valued on every one after it",
+				"parameters":  indexParameters(),
+				"requestBody": formBody(indexParameters()),
+				"responses": clientErrors(map[string]any{
+					"201": pageOrJSON("The new index; Location points at it.", types.Index{}),
+					"404": errorResponse(s, "No snapshot is stored yet."),
+				}),
+			},
+		},
+		"/api/indices/{index}": map[string]any{
+			"get":  index,
+			"post": postIndex,
+			"delete": map[string]any{
+				"summary":    "Remove a custom index",
+				"parameters": []any{indexPath, apiKeyParameter()},
+				"responses": clientErrors(map[string]any{
+					"204": map[string]any{"description": "The index was removed."},
+					"404": errorResponse(s, "No such index among the caller's."),
+				}),
+			},
+		},
+		"/api/portfolio": map[string]any{
+			"get":  portfolio,
+			"post": postPortfolio,
+		},
+		"/api/portfolio/holdings": map[string]any{
+			"post": map[string]any{
+				"summary":     "Add a holding",
+				"parameters":  holdingParameters(),
+				"requestBody": formBody(holdingParameters()),
+				"responses": clientErrors(map[string]any{
+					"201": pageOrJSON("The new holding as JSON, or the updated portfolio page; Location points at the holding.", types.HoldingValue{}),
+				}),
+			},
+		},
+		"/api/portfolio/holdings/{holding}": map[string]any{
+			"get":  holding,
+			"post": postHolding,
+			"delete": map[string]any{
+				"summary":    "Remove a holding",
+				"parameters": []any{holdingPath, apiKeyParameter()},
+				"responses": clientErrors(map[string]any{
+					"204": map[string]any{"description": "The holding was removed."},
+					"404": errorResponse(s, "No such holding in the caller's portfolio."),
+				}),
+			},
+		},
+		"/api/portfolio/transactions": map[string]any{
+			"get": map[string]any{
+				"summary":    "The imported transactions; the HTML format shows the P&L page",
+				"parameters": []any{apiKeyParameter(), methodParameter(), formatParameter()},
+				"responses": clientErrors(map[string]any{
+					"200": pageOrJSON("Transactions in import order.", []types.Transaction{}),
+				}),
+			},
+			"post": map[string]any{
+				"summary":     "Import transactions from a CSV export",
+				"parameters":  importParameters(),
+				"requestBody": importBody,
+				"responses": clientErrors(map[string]any{
+					"201": pageOrJSON("Import summary as JSON, or the P&L page. Transactions imported before are counted as duplicates.", types.ImportResult{}),
+				}),
+			},
+			"delete": map[string]any{
+				"summary":    "Remove every imported transaction",
+				"parameters": []any{apiKeyParameter()},
+				"responses": clientErrors(map[string]any{
+					"204": map[string]any{"description": "The transactions were removed."},
+				}),
+			},
+		},
+		"/api/portfolio/pnl": map[string]any{
+			"get":  pnl,
+			"post": postPnL,
+		},
+		"/metrics": map[string]any{
+			"get": map[string]any{
+				"summary":   "Prometheus metrics",
+				"responses": map[string]any{"200": textResponse("Metrics in the Prometheus text format.", "text/plain")},
+			},
+		},
+		"/healthz": map[string]any{
+			"get": map[string]any{
+				"summary": "Liveness probe",
+				"responses": map[string]any{
+					"200": map[string]any{"description": "The process is up.", "content": jsonContent(s.ref(healthResponse{}))},
+				},
+			},
+		},
+		"/readyz": map[string]any{
+			"get": map[string]any{
+				"summary": "Readiness probe with per-dependency status",
+				"responses": map[string]any{
+					"200": map[string]any{"description": "All dependencies are healthy.", "content": jsonContent(s.ref(readinessResponse{}))},
+					"503": map[string]any{"description": "A dependency is failing or the server is shutting down.", "content": jsonContent(s.ref(readinessResponse{}))},
+				},
+			},
+		},
+		"/openapi.json": map[string]any{
+			"get": map[string]any{
+				"summary":   "This document",
+				"responses": map[string]any{"200": textResponse("OpenAPI 3 document.", "application/json")},
+			},
+		},
+		"/docs": map[string]any{
+			"get": map[string]any{
+				"summary":   "Interactive API documentation",
+				"responses": map[string]any{"200": textResponse("HTML page.", "text/html")},
+			},
+		},
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "Cryptosummary API",
+			"version":     "1.0.0",
+			"description": "Cryptocurrency listings from CoinMarketCap cross-checked against CoinGecko.",
+		},
+		"paths":      paths,
+		"components": map[string]any{"schemas": map[string]any(s)},
+	}
+}
+
+func copyOperation(op map[string]any) map[string]any {
+	c := make(map[string]any, len(op))
+	for k, v := range op {
+		c[k] = v
+	}
+	return c
+}
+
+// formSchema describes a form body with the same fields as the query
+// parameters.
+func formSchema(params []any) map[string]any {
+	props := make(map[string]any)
+	var required []string
+	for _, p := range params {
+		p := p.(map[string]any)
+		name := p["name"].(string)
+		props[name] = p["schema"]
+		if req, _ := p["required"].(bool); req {
+			required = append(required, name)
+		}
+	}
+	return map[string]any{"type": "object", "properties": props, "required": required}
+}
+
+func openapiHandler(spec map[string]any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, spec)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestSpecRoutes fails when a route is served but not documented or
+// documented but not served, so a handler cannot be added or removed
+// without updating buildSpec.
+func TestSpecRoutes(t *testing.T) {
+	served := servedRoutes(t)
+	paths := buildSpec()["paths"].(map[string]any)
+
+	documented := make(map[string]bool, len(paths))
+	for p := range paths {
+		documented[routePattern(p)] = true
+	}
+	for p := range served {
+		if !documented[p] {
+			t.Errorf("route %s is served but not documented", p)
+		}
+	}
+	for p := range paths {
+		if _, ok := served[routePattern(p)]; !ok {
+			t.Errorf("path %s is documented but not served", p)
+		}
+	}
+}
+
+// TestSpecParameters fails when a handler reads a parameter the spec does
+// not document for its route, or the spec documents one no handler reads.
+func TestSpecParameters(t *testing.T) {
+	served := servedRoutes(t)
+	handlers := routeHandlers(t)
+	reads := parameterReads(t)
+	paths := buildSpec()["paths"].(map[string]any)
+
+	documented := make(map[string]map[string]bool)
+	for p, ops := range paths {
+		pattern := routePattern(p)
+		if documented[pattern] == nil {
+			documented[pattern] = make(map[string]bool)
+		}
+		for _, op := range ops.(map[string]any) {
+			for name := range operationParameters(op.(map[string]any)) {
+				documented[pattern][name] = true
+			}
+		}
+	}
+
+	for pattern := range served {
+		handler, ok := handlers[pattern]
+		if !ok {
+			t.Errorf("no handler found for route %s", pattern)
+			continue
+		}
+		parsed := reads(handler)
+		for _, name := range sortedKeys(parsed) {
+			if !documented[pattern][name] {
+				t.Errorf("%s reads parameter %q, which the spec does not document", pattern, name)
+			}
+		}
+		for _, name := range sortedKeys(documented[pattern]) {
+			if !parsed[name] {
+				t.Errorf("spec documents parameter %q of %s, which %s does not read", name, pattern, handler)
+			}
+		}
+	}
+}
+
+// servedRoutes builds the handler and returns the patterns registered on
+// its mux.
+func servedRoutes(t *testing.T) map[string]bool {
+	t.Helper()
+
+	saved := cfg
+	t.Cleanup(func() { cfg = saved })
+	cfg.DataDir = t.TempDir()
+	cfg.SnapshotInterval = 0
+
+	registeredRoutes = nil
+	if _, err := newHandler(); err != nil {
+		t.Fatalf("newHandler() error = %v", err)
+	}
+
+	served := make(map[string]bool, len(registeredRoutes))
+	for _, p := range registeredRoutes {
+		served[p] = true
+	}
+	return served
+}
+
+// operationParameters returns the query and body fields of an operation.
+// Path parameters are part of the route, not read from the request values.
+func operationParameters(op map[string]any) map[string]bool {
+	names := make(map[string]bool)
+	params, _ := op["parameters"].([]any)
+	for _, p := range params {
+		p := p.(map[string]any)
+		if p["in"] == "query" {
+			names[p["name"].(string)] = true
+		}
+	}
+	body, _ := op["requestBody"].(map[string]any)
+	content, _ := body["content"].(map[string]any)
+	for _, media := range content {
+		schema, _ := media.(map[string]any)["schema"].(map[string]any)
+		props, _ := schema["properties"].(map[string]any)
+		for name := range props {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// parsePackage parses the non-test sources of the package.
+func parsePackage(t *testing.T) []*ast.File {
+	t.Helper()
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(fi fs.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatalf("parse package: %v", err)
+	}
+	var files []*ast.File
+	for _, f := range pkgs["main"].Files {
+		files = append(files, f)
+	}
+	return files
+}
+
+// routeHandlers maps every pattern passed to handle in the source to the
+// function serving it, following handler constructors such as
+// openapiHandler(spec).
+func routeHandlers(t *testing.T) map[string]string {
+	handlers := make(map[string]string)
+	for _, f := range parsePackage(t) {
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) != 3 {
+				return true
+			}
+			if fn, ok := call.Fun.(*ast.Ident); !ok || fn.Name != "handle" {
+				return true
+			}
+			pattern, ok := stringLiteral(call.Args[1])
+			if !ok {
+				return true
+			}
+			handler := call.Args[2]
+			if c, ok := handler.(*ast.CallExpr); ok {
+				handler = c.Fun
+			}
+			if id, ok := handler.(*ast.Ident); ok {
+				handlers[pattern] = id.Name
+			}
+			return true
+		})
+	}
+	return handlers
+}
+
+// paramGetters are the params methods that read a request value by name.
+var paramGetters = map[string]bool{
+	"str": true, "list": true, "integer": true, "number": true,
+	"enum": true, "timestamp": true, "date": true,
+}
+
+// parameterReads returns a function listing the parameters a function of
+// the package reads, directly or through the package functions it calls
+// or references. A parameter name passed on as an argument, like
+// parseCurrencies(p, "convert"), is read where the callee uses it.
+func parameterReads(t *testing.T) func(fn string) map[string]bool {
+	type funcInfo struct {
+		reads map[string]bool
+		// nameArgs are the indexes of arguments used as parameter names
+		nameArgs map[int]bool
+		// calls are the package functions used, with their literal
+		// arguments by index
+		calls map[string][]map[int]string
+	}
+
+	decls := make(map[string]*ast.FuncDecl)
+	for _, f := range parsePackage(t) {
+		for _, d := range f.Decls {
+			if fd, ok := d.(*ast.FuncDecl); ok && fd.Recv == nil {
+				decls[fd.Name.Name] = fd
+			}
+		}
+	}
+
+	infos := make(map[string]*funcInfo, len(decls))
+	for name, fd := range decls {
+		info := &funcInfo{reads: make(map[string]bool), nameArgs: make(map[int]bool), calls: make(map[string][]map[int]string)}
+		infos[name] = info
+
+		argIndex := make(map[string]int)
+		i := 0
+		for _, field := range fd.Type.Params.List {
+			for _, n := range field.Names {
+				argIndex[n.Name] = i
+				i++
+			}
+			if len(field.Names) == 0 {
+				i++
+			}
+		}
+
+		ast.Inspect(fd.Body, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.CallExpr:
+				if sel, ok := n.Fun.(*ast.SelectorExpr); ok && len(n.Args) > 0 && readsValue(sel) {
+					if name, ok := stringLiteral(n.Args[0]); ok {
+						info.reads[name] = true
+					} else if id, ok := n.Args[0].(*ast.Ident); ok {
+						if idx, ok := argIndex[id.Name]; ok {
+							info.nameArgs[idx] = true
+						}
+					}
+				}
+				if id, ok := n.Fun.(*ast.Ident); ok && decls[id.Name] != nil {
+					lits := make(map[int]string)
+					for i, a := range n.Args {
+						if s, ok := stringLiteral(a); ok {
+							lits[i] = s
+						}
+					}
+					info.calls[id.Name] = append(info.calls[id.Name], lits)
+				}
+			case *ast.Ident:
+				if decls[n.Name] != nil && n.Name != name {
+					if _, ok := info.calls[n.Name]; !ok {
+						info.calls[n.Name] = nil
+					}
+				}
+			}
+			return true
+		})
+	}
+
+	return func(fn string) map[string]bool {
+		reads := make(map[string]bool)
+		seen := make(map[string]bool)
+		var visit func(name string)
+		visit = func(name string) {
+			if seen[name] || infos[name] == nil {
+				return
+			}
+			seen[name] = true
+			info := infos[name]
+			for r := range info.reads {
+				reads[r] = true
+			}
+			for callee, sites := range info.calls {
+				for _, lits := range sites {
+					for idx := range infos[callee].nameArgs {
+						if s, ok := lits[idx]; ok {
+							reads[s] = true
+						}
+					}
+				}
+				visit(callee)
+			}
+		}
+		visit(fn)
+		return reads
+	}
+}
+
+// readsValue reports whether sel is a params getter, a Get on the raw
+// request values or a multipart file field.
+func readsValue(sel *ast.SelectorExpr) bool {
+	switch sel.Sel.Name {
+	case "FormFile":
+		return true
+	case "Get":
+		switch x := sel.X.(type) {
+		case *ast.Ident:
+			return x.Name == "values"
+		case *ast.SelectorExpr:
+			return x.Sel.Name == "values"
+		}
+		return false
+	}
+	return paramGetters[sel.Sel.Name]
+}
+
+func stringLiteral(e ast.Expr) (string, bool) {
+	lit, ok := e.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+	s, err := strconv.Unquote(lit.Value)
+	return s, err == nil
+}
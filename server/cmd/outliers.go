@@ -0,0 +1,139 @@
+package main
+
+import "server/types"
+
+// outlierMethods are the outlier tests of the listings endpoint with their
+// default thresholds: 3 standard deviations, a modified z-score of 3.5 and
+// Tukey's 1.5 interquartile ranges.
+var outlierMethods = []struct {
+	name      string
+	threshold float64
+	fences    func(values []float64, k float64) (lower, upper float64)
+}{
+	{"zscore", 3, CalculateZScoreFences},
+	{"mad", 3.5, CalculateMADFences},
+	{"iqr", 1.5, CalculateIQRFences},
+}
+
+var outlierPolicies = []string{"flag", "exclude"}
+
+// outlierRequest is the outlier detection asked for by a listings request.
+type outlierRequest struct {
+	Method    string
+	Metric    string
+	Threshold float64
+	Policy    string
+}
+
+func outlierMethodNames() []string {
+	names := make([]string, len(outlierMethods))
+	for i, m := range outlierMethods {
+		names[i] = m.name
+	}
+	return names
+}
+
+// outlierMetricNames are the fields outliers can be detected in, the
+// metrics of /api/compare.
+func outlierMetricNames() []string {
+	names := make([]string, len(compareMetrics))
+	for i, m := range compareMetrics {
+		names[i] = m.name
+	}
+	return names
+}
+
+// parseOutliers reads the outlier parameters of the listings endpoint,
+// nil when outliers is absent.
+func parseOutliers(p *params) *outlierRequest {
+	method := p.enum("outliers", "", outlierMethodNames(), false)
+	if method == "" {
+		return nil
+	}
+
+	req := &outlierRequest{
+		Method: method,
+		Metric: p.enum("outlier_metric", "price", outlierMetricNames(), false),
+		Policy: p.enum("outlier_policy", "flag", outlierPolicies, false),
+	}
+	// the stats are computed on the price, leaving out coins flagged on
+	// another metric would move them for reasons the response does not show
+	if req.Policy == "exclude" && req.Metric != "price" {
+		p.fail("outlier_policy", "exclude needs outlier_metric price, the metric the stats are computed on")
+	}
+	for _, m := range outlierMethods {
+		if m.name == method {
+			req.Threshold = m.threshold
+		}
+	}
+	if k := p.number("outlier_threshold"); k != nil {
+		if *k == 0 {
+			p.fail("outlier_threshold", "must be greater than 0")
+		}
+		req.Threshold = *k
+	}
+	return req
+}
+
+// detectOutliers flags the listings outside the fences of req over all
+// listings with a value for req.Metric.
+func detectOutliers(listings []types.CryptoListing, req outlierRequest) *types.OutlierReport {
+	report := &types.OutlierReport{
+		Method:    req.Method,
+		Metric:    req.Metric,
+		Threshold: req.Threshold,
+		Policy:    req.Policy,
+		Coins:     make(map[int]*types.Outlier),
+	}
+
+	var value func(l types.CryptoListing) (float64, bool)
+	for _, m := range compareMetrics {
+		if m.name == req.Metric {
+			value = m.value
+		}
+	}
+	var values []float64
+	for _, l := range listings {
+		if v, ok := value(l); ok {
+			values = append(values, v)
+		}
+	}
+	report.Checked = len(values)
+	if len(values) == 0 {
+		return report
+	}
+
+	var lower, upper float64
+	for _, m := range outlierMethods {
+		if m.name == req.Method {
+			lower, upper = m.fences(values, req.Threshold)
+		}
+	}
+	report.Lower, report.Upper = &lower, &upper
+
+	for _, l := range listings {
+		v, ok := value(l)
+		switch {
+		case !ok:
+		case v > upper:
+			report.Coins[l.ID] = &types.Outlier{Value: v, Side: "above"}
+		case v < lower:
+			report.Coins[l.ID] = &types.Outlier{Value: v, Side: "below"}
+		}
+	}
+	if req.Policy == "exclude" {
+		report.Excluded = len(report.Coins)
+	}
+	return report
+}
+
+// withoutOutliers returns the listings report did not flag.
+func withoutOutliers(listings []types.CryptoListing, report *types.OutlierReport) []types.CryptoListing {
+	kept := make([]types.CryptoListing, 0, len(listings))
+	for _, l := range listings {
+		if report.Coins[l.ID] == nil {
+			kept = append(kept, l)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"server/types"
+	"strconv"
+	"sync"
+)
+
+// listingsQuery is everything the listings endpoint sends upstream.
+type listingsQuery struct {
+	Start   int
+	Limit   int
+	Order   string
+	SortDir string
+	Filter  *types.ListingFilter
+	// Convert are the quote currencies, USD when empty
+	Convert []string
+}
+
+var sortDirOptions = []string{"desc", "asc"}
+
+// fetchListings requests the listings in pages of at most
+// cfg.CMCMaxPageSize, fetching the pages concurrently and merging them in
+// order. A short page means the listing ended, later pages are dropped.
+func fetchListings(ctx context.Context, client *http.Client, q listingsQuery) (types.Response, error) {
+	type page struct {
+		start, limit int
+		resp         types.Response
+		err          error
+	}
+
+	var pages []*page
+	for offset := 0; offset < q.Limit; offset += cfg.CMCMaxPageSize {
+		size := q.Limit - offset
+		if size > cfg.CMCMaxPageSize {
+			size = cfg.CMCMaxPageSize
+		}
+		pages = append(pages, &page{start: q.Start + offset, limit: size})
+	}
+
+	var wg sync.WaitGroup
+	for _, p := range pages {
+		wg.Add(1)
+		go func(p *page) {
+			defer wg.Done()
+			p.resp, p.err = createCoinMarketCapRequest(ctx, client, q, p.start, p.limit)
+		}(p)
+	}
+	wg.Wait()
+
+	var merged types.Response
+	for i, p := range pages {
+		if p.err != nil {
+			return types.Response{}, p.err
+		}
+		if i == 0 {
+			merged.Status = p.resp.Status
+		}
+		merged.Data = append(merged.Data, p.resp.Data...)
+		if len(p.resp.Data) < p.limit {
+			break
+		}
+	}
+	if merged.Data == nil {
+		merged.Data = []types.CryptoListing{}
+	}
+
+	return merged, nil
+}
+
+// newPagination builds the next and previous links. They repeat every
+// parameter of the current request except the api-key. fetched is the
+// number of rows CMC returned before filtering; fewer than the limit means
+// this is the last page.
+func newPagination(path string, values url.Values, q listingsQuery, fetched int) types.Pagination {
+	p := types.Pagination{Start: q.Start, Limit: q.Limit, SortDir: q.SortDir}
+
+	if fetched >= q.Limit {
+		p.Next = pageLink(path, values, q.Start+q.Limit)
+	}
+	if q.Start > 1 {
+		prev := q.Start - q.Limit
+		if prev < 1 {
+			prev = 1
+		}
+		p.Prev = pageLink(path, values, prev)
+	}
+	return p
+}
+
+func pageLink(path string, values url.Values, start int) string {
+	params := url.Values{}
+	for k, v := range values {
+		if k != "api-key" {
+			params[k] = v
+		}
+	}
+	params.Set("start", strconv.Itoa(start))
+	return path + "?" + params.Encode()
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"server/types"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxBodyBytes bounds JSON request bodies.
+const maxBodyBytes = 1 << 20
+
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validationError lists every invalid parameter of a request, so a client
+// can fix them all in one round trip.
+type validationError struct {
+	Fields []fieldError `json:"fields"`
+}
+
+func (e *validationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return "invalid parameters: " + strings.Join(msgs, "; ")
+}
+
+// requestValues merges the query string with the request body. Forms are
+// parsed by net/http; a JSON object body is flattened into the same
+// url.Values, numbers and booleans as their literal text and arrays as comma
+// separated lists. Body values take precedence over the query string.
+func requestValues(r *http.Request) (url.Values, error) {
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if ct != "application/json" {
+		if err := r.ParseForm(); err != nil {
+			return nil, &validationError{Fields: []fieldError{{Field: "body", Message: "malformed form data"}}}
+		}
+		return r.Form, nil
+	}
+
+	values := r.URL.Query()
+
+	var body map[string]any
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxBodyBytes))
+	dec.UseNumber()
+	if err := dec.Decode(&body); err != nil {
+		return nil, &validationError{Fields: []fieldError{{Field: "body", Message: "must be a JSON object"}}}
+	}
+
+	var errs []fieldError
+	for k, v := range body {
+		// no parameter has an empty name, like an empty form key it is
+		// ignored
+		if k == "" {
+			continue
+		}
+		s, ok := jsonScalar(v)
+		if !ok {
+			errs = append(errs, fieldError{Field: k, Message: "must be a string, number, boolean or array of those"})
+			continue
+		}
+		values.Set(k, s)
+	}
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+		return nil, &validationError{Fields: errs}
+	}
+	return values, nil
+}
+
+func jsonScalar(v any) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return "", true
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case []any:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			s, ok := jsonScalar(item)
+			if !ok {
+				return "", false
+			}
+			if _, nested := item.([]any); nested {
+				return "", false
+			}
+			parts[i] = s
+		}
+		return strings.Join(parts, ","), true
+	}
+	return "", false
+}
+
+// params reads typed values out of url.Values and collects a fieldError for
+// every value that does not parse or is out of bounds.
+type params struct {
+	values url.Values
+	errs   []fieldError
+}
+
+func (p *params) fail(field, format string, args ...any) {
+	p.errs = append(p.errs, fieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (p *params) err() error {
+	if len(p.errs) == 0 {
+		return nil
+	}
+	return &validationError{Fields: p.errs}
+}
+
+func (p *params) str(name string) string {
+	return strings.TrimSpace(p.values.Get(name))
+}
+
+func (p *params) list(name string) []string {
+	return splitList(p.values.Get(name))
+}
+
+// integer parses name as a whole number in [min, max]. A missing value is
+// an error when required and def otherwise.
+func (p *params) integer(name string, def, min, max int, required bool) int {
+	v := p.str(name)
+	if v == "" {
+		if required {
+			p.fail(name, "is required")
+		}
+		return def
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		p.fail(name, "must be a whole number")
+		return def
+	}
+	if n < min || n > max {
+		p.fail(name, "must be between %d and %d", min, max)
+		return def
+	}
+	return n
+}
+
+// number parses an optional non-negative float, nil when absent.
+func (p *params) number(name string) *float64 {
+	v := p.str(name)
+	if v == "" {
+		return nil
+	}
+
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+		p.fail(name, "must be a number")
+		return nil
+	}
+	if n < 0 {
+		p.fail(name, "must not be negative")
+		return nil
+	}
+	return &n
+}
+
+// date parses name as a YYYY-MM-DD day in UTC that is not in the future.
+// A missing value is an error when required and the zero time otherwise.
+func (p *params) date(name string, required bool) time.Time {
+	v := p.str(name)
+	if v == "" {
+		if required {
+			p.fail(name, "is required")
+		}
+		return time.Time{}
+	}
+
+	d, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		p.fail(name, "must be a date such as 2024-01-31")
+		return time.Time{}
+	}
+	if d.After(time.Now().UTC()) {
+		p.fail(name, "must not be in the future")
+		return time.Time{}
+	}
+	return d
+}
+
+// timestampLayouts are the accepted forms of a timestamp parameter besides
+// Unix seconds; times without a zone are UTC.
+var timestampLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", time.DateOnly}
+
+// minUnixSeconds is the smallest number taken as Unix seconds, in 1973.
+// Shorter numbers such as 2024 or 20240101 are a year or a date, not a
+// time in 1970.
+const minUnixSeconds = 100_000_000
+
+// timestamp parses an optional point in time that is not in the future,
+// the zero time when absent.
+func (p *params) timestamp(name string) time.Time {
+	v := p.str(name)
+	if v == "" {
+		return time.Time{}
+	}
+
+	const want = "must be Unix seconds or a time such as 2024-01-31T12:00:00Z"
+	var t time.Time
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if secs < minUnixSeconds {
+			p.fail(name, want)
+			return time.Time{}
+		}
+		t = time.Unix(secs, 0).UTC()
+	} else {
+		for _, layout := range timestampLayouts {
+			if t, err = time.Parse(layout, v); err == nil {
+				break
+			}
+		}
+		if t.IsZero() {
+			p.fail(name, want)
+			return time.Time{}
+		}
+	}
+	if t.After(time.Now()) {
+		p.fail(name, "must not be in the future")
+		return time.Time{}
+	}
+	return t
+}
+
+// enum checks name against options. A missing value is an error when
+// required and def otherwise.
+func (p *params) enum(name, def string, options []string, required bool) string {
+	v := p.str(name)
+	if v == "" {
+		if required {
+			p.fail(name, "is required, one of: %s", strings.Join(options, ", "))
+		}
+		return def
+	}
+	if !contains(options, v) {
+		p.fail(name, "must be one of: %s", strings.Join(options, ", "))
+		return def
+	}
+	return v
+}
+
+// clientParams decodes the parameters of an endpoint that needs an api-key
+// and checks the key. The caller reads its own fields from p and reports
+// p.err() afterwards, so a bad key is answered before bad parameters.
+// format is usable even when err is set.
+func clientParams(r *http.Request) (p *params, format string, err error) {
+	values, err := requestValues(r)
+	if err != nil {
+		return nil, preferredFormat(r), err
+	}
+
+	p = &params{values: values}
+	format = p.enum("format", preferredFormat(r), formatOptions, false)
+	if !validAPIKey(values.Get("api-key")) {
+		return nil, format, errInvalidAPIKey()
+	}
+	return p, format, nil
+}
+
+// validAPIKey reports whether key is one of cfg.APIKeys.
+func validAPIKey(key string) bool {
+	return key != "" && contains(cfg.APIKeys, key)
+}
+
+// listingsRequest is the decoded and validated input of the listings
+// endpoint.
+type listingsRequest struct {
+	APIKey string
+	Format string
+	Query  listingsQuery
+	// At asks for the listing as it was at that time, rebuilt from the
+	// nearest stored snapshot; zero means live
+	At time.Time
+	// Risk is the candle interval of the risk metrics added for the listed
+	// coins, empty for none; RiskFreeRate is the yearly rate they use
+	Risk         string
+	RiskFreeRate float64
+	// Outliers is the outlier detection run over the page, nil for none
+	Outliers *outlierRequest
+	// Values are the raw parameters, used to build the pagination links
+	Values url.Values
+}
+
+var formatOptions = []string{"html", "json"}
+
+// preferredFormat is the response format used when the request has no
+// format parameter: JSON for clients that accept or send JSON, HTML for
+// browsers.
+func preferredFormat(r *http.Request) string {
+	if strings.Contains(r.Header.Get("Accept"), "application/json") || strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		return "json"
+	}
+	return "html"
+}
+
+// parseListingsRequest decodes the listings parameters from the query
+// string, a form or a JSON body. Values and the api-key are set even when
+// fields are invalid, so the caller can answer 401 before 400; Values is nil
+// only when the body could not be decoded at all.
+func parseListingsRequest(r *http.Request) (listingsRequest, error) {
+	values, err := requestValues(r)
+	if err != nil {
+		return listingsRequest{Format: preferredFormat(r)}, err
+	}
+
+	p := &params{values: values}
+	req := listingsRequest{APIKey: values.Get("api-key"), Values: values}
+
+	req.Format = p.enum("format", preferredFormat(r), formatOptions, false)
+
+	req.Query.Limit = p.integer("limit", 0, 1, cfg.MaxListingLimit, true)
+	req.Query.Start = p.integer("start", 1, 1, cfg.MaxListingStart, false)
+	req.Query.Order = p.enum("order", "", orderOptions, true)
+	req.Query.SortDir = p.enum("sort_dir", "desc", sortDirOptions, false)
+	req.Query.Filter = parseListingFilter(p)
+	req.At = p.timestamp("at")
+	if !req.At.IsZero() {
+		checkSnapshotQuery(p, req.Query)
+	}
+	req.Risk = p.enum("risk", "", candleIntervalNames(), false)
+	if req.Risk != "" {
+		req.RiskFreeRate = riskFreeRate(p)
+	}
+	req.Outliers = parseOutliers(p)
+
+	return req, p.err()
+}
+
+// checkSnapshotQuery rejects an at= query a snapshot cannot answer as it
+// was: snapshots keep only the top cfg.SnapshotLimit coins by market cap, so
+// any other order, or a page reaching past them, would be missing coins.
+func checkSnapshotQuery(p *params, q listingsQuery) {
+	if q.Order != "" && q.Order != "market_cap" {
+		p.fail("order", "must be market_cap with at, snapshots keep only the top %d coins by market cap", cfg.SnapshotLimit)
+	}
+	if q.SortDir == "asc" {
+		p.fail("sort_dir", "must be desc with at, snapshots keep only the top %d coins by market cap", cfg.SnapshotLimit)
+	}
+	if q.Start > 0 && q.Limit > 0 && q.Start+q.Limit-1 > cfg.SnapshotLimit {
+		p.fail("start", "start+limit-1 must not exceed %d with at, snapshots keep only that many coins", cfg.SnapshotLimit)
+	}
+}
+
+// parseListingFilter reads the optional filter parameters of the listings
+// endpoint. It returns nil when no filter was given.
+func parseListingFilter(p *params) *types.ListingFilter {
+	f := &types.ListingFilter{
+		Tags:         p.list("tags"),
+		ExcludeTags:  p.list("exclude_tags"),
+		Platforms:    p.list("platform"),
+		PriceMin:     p.number("price_min"),
+		PriceMax:     p.number("price_max"),
+		MarketCapMin: p.number("market_cap_min"),
+		MarketCapMax: p.number("market_cap_max"),
+		Volume24hMin: p.number("volume_24h_min"),
+	}
+
+	if f.PriceMin != nil && f.PriceMax != nil && *f.PriceMin > *f.PriceMax {
+		p.fail("price_min", "must not be greater than price_max")
+	}
+	if f.MarketCapMin != nil && f.MarketCapMax != nil && *f.MarketCapMin > *f.MarketCapMax {
+		p.fail("market_cap_min", "must not be greater than market_cap_max")
+	}
+
+	if len(f.Tags) == 0 && len(f.ExcludeTags) == 0 && len(f.Platforms) == 0 &&
+		f.PriceMin == nil && f.PriceMax == nil && f.MarketCapMin == nil && f.MarketCapMax == nil && f.Volume24hMin == nil {
+		return nil
+	}
+	return f
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseRequests builds the same raw input as a query string, a form body
+// and a JSON body, the three ways parameters reach the handlers.
+func parseRequests(raw string) []*http.Request {
+	get := httptest.NewRequest(http.MethodGet, "/", nil)
+	get.URL.RawQuery = raw
+
+	form := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(raw))
+	form.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	js := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(raw))
+	js.Header.Set("Content-Type", "application/json")
+
+	return []*http.Request{get, form, js}
+}
+
+// checkFieldErrors fails unless err is nil or a validationError naming a
+// field and a message for every problem.
+func checkFieldErrors(t *testing.T, err error) *validationError {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var verr *validationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("error %v (%T) is not a validationError", err, err)
+	}
+	if len(verr.Fields) == 0 {
+		t.Fatalf("validationError without fields")
+	}
+	for _, f := range verr.Fields {
+		if f.Field == "" || f.Message == "" {
+			t.Fatalf("incomplete field error %+v", f)
+		}
+	}
+	return verr
+}
+
+func hasField(verr *validationError, field string) bool {
+	if verr == nil {
+		return false
+	}
+	for _, f := range verr.Fields {
+		if f.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+func FuzzParseListingsRequest(f *testing.F) {
+	for _, seed := range []string{
+		"api-key=123&limit=10&order=market_cap",
+		"limit=0&order=bogus&sort_dir=up",
+		"limit=10&order=price&price_min=5&price_max=1&tags=defi,,layer-1",
+		"limit=-1&start=%zz&at=yesterday&risk=2d&risk_free=1.5",
+		"limit=1e3&order=name&outliers=mad&outlier_threshold=NaN&outlier_policy=drop",
+		"limit=10&order=price&outliers=iqr&outlier_metric=max_supply&outlier_threshold=0",
+		`{"limit": 10, "order": "price", "tags": ["defi", "meme"]}`,
+		`{"limit": {"nested": true}, "order": [["a"]]}`,
+		`{"limit": "10", "at": 99999999999999999999}`,
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		for _, r := range parseRequests(raw) {
+			req, err := parseListingsRequest(r)
+			checkFieldErrors(t, err)
+			if !contains(formatOptions, req.Format) {
+				t.Fatalf("format %q for %q", req.Format, raw)
+			}
+			if err != nil {
+				continue
+			}
+
+			q := req.Query
+			if q.Limit < 1 || q.Limit > cfg.MaxListingLimit || q.Start < 1 || q.Start > cfg.MaxListingStart {
+				t.Fatalf("limit %d, start %d accepted from %q", q.Limit, q.Start, raw)
+			}
+			if !contains(orderOptions, q.Order) || !contains(sortDirOptions, q.SortDir) {
+				t.Fatalf("order %q %q accepted from %q", q.Order, q.SortDir, raw)
+			}
+			if req.At.After(time.Now()) {
+				t.Fatalf("future at %v accepted from %q", req.At, raw)
+			}
+			if o := req.Outliers; o != nil && (!(o.Threshold > 0) || math.IsInf(o.Threshold, 0)) {
+				t.Fatalf("outlier threshold %v accepted from %q", o.Threshold, raw)
+			}
+		}
+	})
+}
+
+func FuzzParseParams(f *testing.F) {
+	for _, seed := range []string{
+		"n=5&x=1.5&e=b&d=2024-01-31&ts=2024-01-31T12:00:00Z&l=a,b",
+		"n=abc&x=-1&e=z&d=2024-13-01&ts=1e9&l=,,",
+		"n=99999999999999999999&x=Inf&d=2999-01-01&ts=4102444800",
+		"n=%20%207%20&x=NaN&ts=2024-01-31T12:00",
+		"n&x&e&d&ts",
+		`{"n": 7, "x": 0.5, "e": "a", "l": ["a", 1, true]}`,
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		for _, r := range parseRequests(raw) {
+			values, err := requestValues(r)
+			if checkFieldErrors(t, err); err != nil {
+				continue
+			}
+
+			p := &params{values: values}
+			n := p.integer("n", 1, 1, 10, false)
+			x := p.number("x")
+			e := p.enum("e", "a", []string{"a", "b"}, false)
+			d := p.date("d", false)
+			ts := p.timestamp("ts")
+			p.list("l")
+			verr := checkFieldErrors(t, p.err())
+
+			if n < 1 || n > 10 {
+				t.Fatalf("integer %d out of bounds from %q", n, raw)
+			}
+			if v := p.str("n"); v != "" {
+				if i, err := strconv.Atoi(v); (err != nil || i < 1 || i > 10) != hasField(verr, "n") {
+					t.Fatalf("integer %q: field error %v", v, hasField(verr, "n"))
+				}
+			}
+			if x != nil && (*x < 0 || math.IsNaN(*x) || math.IsInf(*x, 0)) {
+				t.Fatalf("number %v accepted from %q", *x, raw)
+			}
+			if x == nil && p.str("x") != "" && !hasField(verr, "x") {
+				t.Fatalf("number %q rejected without a field error", p.str("x"))
+			}
+			if e != "a" && e != "b" {
+				t.Fatalf("enum %q accepted from %q", e, raw)
+			}
+			if v := p.str("e"); v != "" && v != "a" && v != "b" && !hasField(verr, "e") {
+				t.Fatalf("enum %q rejected without a field error", v)
+			}
+			if d.IsZero() && p.str("d") != "" && !hasField(verr, "d") {
+				t.Fatalf("date %q rejected without a field error", p.str("d"))
+			}
+			if ts.After(time.Now()) || ts.IsZero() && p.str("ts") != "" && !hasField(verr, "ts") {
+				t.Fatalf("timestamp %q parsed as %v, field error %v", p.str("ts"), ts, hasField(verr, "ts"))
+			}
+		}
+	})
+}
+
+func TestValidationErrorFields(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		json bool
+		want []fieldError
+	}{
+		{
+			name: "every listings field is reported",
+			raw:  "limit=0&order=bogus&sort_dir=up&price_min=5&price_max=1",
+			want: []fieldError{
+				{Field: "limit", Message: "must be between 1 and " + strconv.Itoa(cfg.MaxListingLimit)},
+				{Field: "order", Message: "must be one of: " + strings.Join(orderOptions, ", ")},
+				{Field: "sort_dir", Message: "must be one of: desc, asc"},
+				{Field: "price_min", Message: "must not be greater than price_max"},
+			},
+		},
+		{
+			name: "missing required fields",
+			raw:  "api-key=123",
+			want: []fieldError{
+				{Field: "limit", Message: "is required"},
+				{Field: "order", Message: "is required, one of: " + strings.Join(orderOptions, ", ")},
+			},
+		},
+		{
+			name: "numbers and outliers",
+			raw:  "limit=x&order=price&market_cap_min=-1&volume_24h_min=abc&outliers=mad&outlier_threshold=0",
+			want: []fieldError{
+				{Field: "limit", Message: "must be a whole number"},
+				{Field: "market_cap_min", Message: "must not be negative"},
+				{Field: "volume_24h_min", Message: "must be a number"},
+				{Field: "outlier_threshold", Message: "must be greater than 0"},
+			},
+		},
+		{
+			name: "at with a query a snapshot cannot answer",
+			raw:  "at=2024-01-01T00:00:00Z&limit=10&start=" + strconv.Itoa(cfg.SnapshotLimit) + "&order=price&sort_dir=asc",
+			want: []fieldError{
+				{Field: "order", Message: "must be market_cap with at, snapshots keep only the top " + strconv.Itoa(cfg.SnapshotLimit) + " coins by market cap"},
+				{Field: "sort_dir", Message: "must be desc with at, snapshots keep only the top " + strconv.Itoa(cfg.SnapshotLimit) + " coins by market cap"},
+				{Field: "start", Message: "start+limit-1 must not exceed " + strconv.Itoa(cfg.SnapshotLimit) + " with at, snapshots keep only that many coins"},
+			},
+		},
+		{
+			name: "excluding outliers of a metric the stats are not computed on",
+			raw:  "limit=10&order=price&outliers=iqr&outlier_metric=volume_24h&outlier_policy=exclude",
+			want: []fieldError{
+				{Field: "outlier_policy", Message: "exclude needs outlier_metric price, the metric the stats are computed on"},
+			},
+		},
+		{
+			name: "JSON body fields that are not scalars, sorted by name",
+			raw:  `{"order": {"a": 1}, "limit": [[1]], "tags": ["defi"]}`,
+			json: true,
+			want: []fieldError{
+				{Field: "limit", Message: "must be a string, number, boolean or array of those"},
+				{Field: "order", Message: "must be a string, number, boolean or array of those"},
+			},
+		},
+		{
+			name: "malformed JSON body",
+			raw:  `{"limit": `,
+			json: true,
+			want: []fieldError{{Field: "body", Message: "must be a JSON object"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.URL.RawQuery = tt.raw
+			if tt.json {
+				r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.raw))
+				r.Header.Set("Content-Type", "application/json")
+			}
+
+			_, err := parseListingsRequest(r)
+			var verr *validationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("parseListingsRequest() error = %v, want a validationError", err)
+			}
+			if !reflect.DeepEqual(verr.Fields, tt.want) {
+				t.Errorf("fields = %+v, want %+v", verr.Fields, tt.want)
+			}
+
+			msgs := make([]string, len(tt.want))
+			for i, f := range tt.want {
+				msgs[i] = f.Field + ": " + f.Message
+			}
+			if want := "invalid parameters: " + strings.Join(msgs, "; "); verr.Error() != want {
+				t.Errorf("Error() = %q, want %q", verr.Error(), want)
+			}
+		})
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	const invalid = "must be Unix seconds or a time such as 2024-01-31T12:00:00Z"
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr string
+	}{
+		{value: "1706702400", want: time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)},
+		{value: "2024-01-31", want: time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)},
+		{value: "2024-01-31T12:00:00Z", want: time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)},
+		{value: "2024", wantErr: invalid},
+		{value: "20240101", wantErr: invalid},
+		{value: "-1706702400", wantErr: invalid},
+		{value: "4102444800", wantErr: "must not be in the future"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			p := &params{values: url.Values{"from": {tt.value}}}
+			got := p.timestamp("from")
+
+			var want []fieldError
+			if tt.wantErr != "" {
+				want = []fieldError{{Field: "from", Message: tt.wantErr}}
+			}
+			if !reflect.DeepEqual(p.errs, want) {
+				t.Errorf("errors = %+v, want %+v", p.errs, want)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("timestamp() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientParamsAPIKeys(t *testing.T) {
+	saved := cfg.APIKeys
+	t.Cleanup(func() { cfg.APIKeys = saved })
+	cfg.APIKeys = []string{"alice-key", "bob-key"}
+
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"alice-key", true},
+		{"bob-key", true},
+		{"carol-key", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/?"+url.Values{"api-key": {tt.key}, "limit": {"x"}}.Encode(), nil)
+		_, _, err := clientParams(r)
+		if tt.want && err != nil {
+			t.Errorf("clientParams(%q) error = %v", tt.key, err)
+		}
+		// a bad key is answered before the bad limit
+		var aerr *apiError
+		if !tt.want && (!errors.As(err, &aerr) || aerr.Code != codeInvalidAPIKey) {
+			t.Errorf("clientParams(%q) error = %v, want the invalid api-key error", tt.key, err)
+		}
+	}
+}
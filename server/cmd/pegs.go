@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	templates "server/html"
+	"server/types"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pegTagSuffix ends the CoinMarketCap tags naming the currency of a
+// stablecoin, such as usd-stablecoin and eur-stablecoin. Every stablecoin
+// is expected to trade at stablecoinPeg units of that currency.
+const (
+	pegTagSuffix  = "-stablecoin"
+	stablecoinPeg = 1.0
+)
+
+// maxPegAlerts bounds the alerts kept and maxPegHistory the checks kept
+// per stablecoin, 30 days of snapshots every 15 minutes, even when
+// cfg.SnapshotRetention keeps snapshots forever. The oldest are dropped
+// first.
+const (
+	maxPegAlerts  = 200
+	maxPegHistory = 30 * 24 * 4
+)
+
+var depegAlerts = newCounterVec("depeg_alerts_total",
+	"Stablecoins breaking or regaining their peg, by state.",
+	"state")
+
+// alertClient posts depeg alerts to cfg.DepegWebhookURL. The receiver is
+// not a data provider, so it does not go through upstreamTransport.
+var alertClient = &http.Client{Timeout: 10 * time.Second}
+
+// pegStore keeps the peg history of every stablecoin and the alerts fired,
+// in one JSON file under cfg.DataDir. Stablecoins are checked on every
+// snapshot, see update.
+type pegStore struct {
+	mu   sync.Mutex
+	path string
+	data pegData
+}
+
+type pegData struct {
+	Updated *time.Time                `json:"updated,omitempty"`
+	Coins   map[int]*types.Stablecoin `json:"coins"`
+	Alerts  []types.PegAlert          `json:"alerts"`
+}
+
+var pegs *pegStore
+
+func openPegStore(dir string) (*pegStore, error) {
+	s := &pegStore{path: filepath.Join(dir, "pegs.json")}
+	if err := readJSONFile(s.path, &s.data); err != nil {
+		return nil, err
+	}
+	if s.data.Coins == nil {
+		s.data.Coins = make(map[int]*types.Stablecoin)
+	}
+	// coins stored before pegs had a currency were all checked against USD
+	for _, c := range s.data.Coins {
+		if c.PegCurrency == "" {
+			c.PegCurrency = "USD"
+		}
+	}
+	return s, nil
+}
+
+// flush stores the peg checks on shutdown, with those a failed write left
+// only in memory.
+func (s *pegStore) flush(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return writeJSONFile(s.path, s.data)
+}
+
+// pegCurrency returns the currency l is pegged to: the one configured in
+// cfg.DepegPegs for its symbol, else the one of its currency stablecoin
+// tag. "" means l is not checked, a stablecoin without a currency tag
+// may be pegged to anything.
+func pegCurrency(l types.CryptoListing) string {
+	symbol := strings.ToUpper(l.Symbol)
+	if contains(cfg.DepegIgnore, symbol) {
+		return ""
+	}
+	if currency, ok := cfg.DepegPegs[symbol]; ok {
+		return currency
+	}
+	for _, tag := range l.Tags {
+		// fiat-stablecoin and the like name a kind, not a currency
+		if code, ok := strings.CutSuffix(tag, pegTagSuffix); ok && len(code) == 3 && isCurrencyCode(strings.ToUpper(code)) {
+			return strings.ToUpper(code)
+		}
+	}
+	return ""
+}
+
+// pegPrice is the price of l in currency: its CoinMarketCap quote when the
+// snapshot has one, else its USD price converted at the rate implied by the
+// CoinGecko prices of the same coin. ok is false when neither is known.
+func pegPrice(l types.CryptoListing, currency string, gecko map[string]float64) (price float64, ok bool) {
+	if q, ok := l.Quote[currency]; ok && q.Price > 0 {
+		return q.Price, true
+	}
+	usd, peg := gecko["usd"], gecko[strings.ToLower(currency)]
+	if usd <= 0 || peg <= 0 {
+		return 0, false
+	}
+	return l.Quote["USD"].Price * peg / usd, true
+}
+
+// monitor returns every stablecoin without its history, largest deviation
+// first, and the alerts, newest first.
+func (s *pegStore) monitor() types.PegMonitor {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := types.PegMonitor{
+		Threshold:   cfg.DepegThreshold,
+		Updated:     s.data.Updated,
+		Stablecoins: make([]types.Stablecoin, 0, len(s.data.Coins)),
+		Alerts:      make([]types.PegAlert, 0, len(s.data.Alerts)),
+	}
+	for _, c := range s.data.Coins {
+		coin := *c
+		coin.History = nil
+		m.Stablecoins = append(m.Stablecoins, coin)
+	}
+	sort.Slice(m.Stablecoins, func(i, j int) bool {
+		a, b := math.Abs(m.Stablecoins[i].Latest.Deviation), math.Abs(m.Stablecoins[j].Latest.Deviation)
+		if a != b {
+			return a > b
+		}
+		return m.Stablecoins[i].ID < m.Stablecoins[j].ID
+	})
+	for i := len(s.data.Alerts) - 1; i >= 0; i-- {
+		m.Alerts = append(m.Alerts, s.data.Alerts[i])
+	}
+	return m
+}
+
+// get returns one stablecoin with its history. ref is resolved like
+// findListing resolves it; of stablecoins sharing a symbol the lowest ID
+// wins.
+func (s *pegStore) get(ref string) (types.Stablecoin, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id, err := strconv.Atoi(ref); err == nil {
+		if c, ok := s.data.Coins[id]; ok {
+			return copyStablecoin(*c), true
+		}
+		return types.Stablecoin{}, false
+	}
+
+	var found *types.Stablecoin
+	for _, match := range []func(c *types.Stablecoin) bool{
+		func(c *types.Stablecoin) bool { return strings.EqualFold(c.Slug, ref) },
+		func(c *types.Stablecoin) bool { return strings.EqualFold(c.Symbol, ref) },
+	} {
+		for _, c := range s.data.Coins {
+			if match(c) && (found == nil || c.ID < found.ID) {
+				found = c
+			}
+		}
+		if found != nil {
+			return copyStablecoin(*found), true
+		}
+	}
+	return types.Stablecoin{}, false
+}
+
+func copyStablecoin(c types.Stablecoin) types.Stablecoin {
+	c.History = append([]types.PegPoint{}, c.History...)
+	return c
+}
+
+// update checks the peg of every stablecoin of snap against its
+// CoinMarketCap quote and a live CoinGecko price, stores the result and
+// fires the alerts for the coins that crossed cfg.DepegThreshold. Only a
+// failure to store is returned; without CoinGecko the check goes on with
+// CoinMarketCap alone, except for pegs it has no quote in.
+func (s *pegStore) update(ctx context.Context, snap types.Snapshot) error {
+	var coins []types.CryptoListing
+	currencies := make(map[int]string)
+	for _, l := range snap.Listings {
+		if currency := pegCurrency(l); currency != "" && l.Quote["USD"].Price > 0 {
+			coins = append(coins, l)
+			currencies[l.ID] = currency
+		}
+	}
+	geckoIDs, geckoPrices := coinGeckoPegPrices(ctx, coins, currencies)
+
+	s.mu.Lock()
+	var alerts []types.PegAlert
+	seen := make(map[int]bool, len(coins))
+	for _, l := range coins {
+		currency := currencies[l.ID]
+		gecko := geckoPrices[geckoIDs[l.ID]]
+		cmcPrice, ok := pegPrice(l, currency, gecko)
+		if !ok {
+			slog.DebugContext(ctx, "No price in the peg currency of a stablecoin", "symbol", l.Symbol, "currency", currency)
+			continue
+		}
+
+		seen[l.ID] = true
+		c, ok := s.data.Coins[l.ID]
+		if !ok || c.PegCurrency != currency {
+			// a history against another currency says nothing about this peg
+			c = &types.Stablecoin{ID: l.ID, Peg: stablecoinPeg, PegCurrency: currency}
+			s.data.Coins[l.ID] = c
+		}
+		c.Name, c.Symbol, c.Slug, c.CoinGeckoID = l.Name, l.Symbol, l.Slug, geckoIDs[l.ID]
+		c.Stale = false
+
+		point, provider := pegPoint(snap.Time, cmcPrice, gecko[strings.ToLower(currency)], c.Peg)
+		c.Latest = point
+		c.History = append(c.History, point)
+
+		breached := math.Abs(point.Deviation) >= cfg.DepegThreshold
+		if breached == c.Depegged {
+			continue
+		}
+		c.Depegged = breached
+		c.DepeggedSince = nil
+		state := "recovered"
+		if breached {
+			since := snap.Time
+			c.DepeggedSince = &since
+			state = "depegged"
+		}
+		alerts = append(alerts, types.PegAlert{
+			Time:      snap.Time,
+			CoinID:    c.ID,
+			Name:      c.Name,
+			Symbol:    c.Symbol,
+			State:     state,
+			Provider:  provider,
+			Deviation: point.Deviation,
+			Threshold: cfg.DepegThreshold,
+		})
+	}
+
+	for id, c := range s.data.Coins {
+		if !seen[id] {
+			c.Stale = true
+		}
+		if cfg.SnapshotRetention > 0 {
+			cutoff := snap.Time.Add(-cfg.SnapshotRetention)
+			i := sort.Search(len(c.History), func(i int) bool { return !c.History[i].Time.Before(cutoff) })
+			c.History = c.History[i:]
+			if len(c.History) == 0 {
+				delete(s.data.Coins, id)
+			}
+		}
+		if n := len(c.History); n > maxPegHistory {
+			c.History = c.History[n-maxPegHistory:]
+		}
+	}
+	s.data.Alerts = append(s.data.Alerts, alerts...)
+	if n := len(s.data.Alerts); n > maxPegAlerts {
+		s.data.Alerts = s.data.Alerts[n-maxPegAlerts:]
+	}
+	updated := snap.Time
+	s.data.Updated = &updated
+
+	err := writeJSONFile(s.path, s.data)
+	s.mu.Unlock()
+
+	for _, a := range alerts {
+		fireDepegAlert(ctx, a)
+	}
+	return err
+}
+
+// pegPoint is the deviation of the prices of one coin from peg; geckoPrice
+// is 0 when CoinGecko has none. provider has the larger deviation.
+func pegPoint(t time.Time, cmcPrice, geckoPrice, peg float64) (point types.PegPoint, provider string) {
+	point = types.PegPoint{
+		Time:         t,
+		CMCPrice:     cmcPrice,
+		CMCDeviation: percentOf(cmcPrice-peg, peg),
+	}
+	point.Deviation, provider = point.CMCDeviation, "coinmarketcap"
+	if geckoPrice > 0 {
+		deviation := percentOf(geckoPrice-peg, peg)
+		point.CoinGeckoPrice, point.CoinGeckoDeviation = &geckoPrice, &deviation
+		if math.Abs(deviation) > math.Abs(point.Deviation) {
+			point.Deviation, provider = deviation, "coingecko"
+		}
+	}
+	return point, provider
+}
+
+// coinGeckoPegPrices finds coins in the CoinGecko coin list and fetches
+// their prices in USD and in their peg currencies in one call, by CoinGecko
+// ID and lower case currency. Failures are logged and leave prices out.
+func coinGeckoPegPrices(ctx context.Context, coins []types.CryptoListing, currencies map[int]string) (ids map[int]string, prices map[string]map[string]float64) {
+	ids = make(map[int]string, len(coins))
+	if len(coins) == 0 {
+		return ids, nil
+	}
+	list, err := coinList.get(ctx, upstreamClient)
+	if err != nil {
+		slog.WarnContext(ctx, "Could not check stablecoins on CoinGecko", "err", err)
+		return ids, nil
+	}
+
+	var wanted []string
+	vs := []string{"usd"}
+	for _, l := range coins {
+		if id := coinGeckoID(list, l); id != "" {
+			ids[l.ID] = id
+			wanted = append(wanted, id)
+			if c := strings.ToLower(currencies[l.ID]); !contains(vs, c) {
+				vs = append(vs, c)
+			}
+		}
+	}
+	if len(wanted) == 0 {
+		return ids, nil
+	}
+	prices, err = createCoinGeckoPricesRequest(ctx, upstreamClient, wanted, vs)
+	if err != nil {
+		slog.WarnContext(ctx, "Could not check stablecoins on CoinGecko", "err", err)
+	}
+	return ids, prices
+}
+
+// coinGeckoID matches l by name and, failing that, by symbol when only one
+// CoinGecko coin has it. Unlike findCoinID it returns "" for no match, a
+// guessed ID would compare the peg against an unrelated coin.
+func coinGeckoID(coins []types.Coin, l types.CryptoListing) string {
+	var bySymbol []string
+	for _, c := range coins {
+		if strings.EqualFold(c.Name, l.Name) {
+			return c.ID
+		}
+		if strings.EqualFold(c.Symbol, l.Symbol) {
+			bySymbol = append(bySymbol, c.ID)
+		}
+	}
+	if len(bySymbol) == 1 {
+		return bySymbol[0]
+	}
+	return ""
+}
+
+// createCoinGeckoPricesRequest returns the CoinGecko prices of ids in the
+// lower case currencies vs, by ID and currency. Prices CoinGecko does not
+// have are left out.
+func createCoinGeckoPricesRequest(ctx context.Context, client *http.Client, ids, vs []string) (map[string]map[string]float64, error) {
+	query := url.Values{"ids": {strings.Join(ids, ",")}, "vs_currencies": {strings.Join(vs, ",")}}
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.coingecko.com/api/v3/simple/price?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("x-cg-demo-api-key", cfg.CoinGeckoAPIKey)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("coingecko returned status %d", resp.StatusCode)
+	}
+
+	var prices map[string]map[string]float64
+	if err := json.NewDecoder(resp.Body).Decode(&prices); err != nil {
+		return nil, err
+	}
+	return prices, nil
+}
+
+// fireDepegAlert logs a with alert=true, counts it in depeg_alerts_total
+// and posts it as JSON to cfg.DepegWebhookURL when one is set.
+func fireDepegAlert(ctx context.Context, a types.PegAlert) {
+	depegAlerts.inc(a.State)
+	slog.WarnContext(ctx, "Stablecoin peg alert",
+		"alert", true,
+		"symbol", a.Symbol,
+		"state", a.State,
+		"provider", a.Provider,
+		"deviation", a.Deviation,
+		"threshold", a.Threshold,
+	)
+
+	if cfg.DepegWebhookURL == "" {
+		return
+	}
+	body, err := json.Marshal(a)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error encoding a depeg alert", "err", err)
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", cfg.DepegWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		slog.ErrorContext(ctx, "Error creating a depeg alert request", "err", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := alertClient.Do(req)
+	if err != nil {
+		slog.WarnContext(ctx, "Could not post a depeg alert", "symbol", a.Symbol, "err", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		slog.WarnContext(ctx, "Depeg alert webhook refused the alert", "symbol", a.Symbol, "status", resp.StatusCode)
+	}
+}
+
+// stablecoinsHandler serves /api/stablecoins, the peg health of every
+// stablecoin tracked.
+func stablecoinsHandler(w http.ResponseWriter, r *http.Request) {
+	p, format, err := clientParams(r)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+	if err := p.err(); err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	if !allowRequest(w, r, format) {
+		return
+	}
+
+	m := pegs.monitor()
+	m.APIKey = p.str("api-key")
+
+	if format == "json" {
+		writeJSON(w, http.StatusOK, m)
+		return
+	}
+	renderHTML(w, r, http.StatusOK, templates.Stablecoins, m)
+}
+
+// stablecoinHandler serves /api/stablecoins/{coin}?from=&to=, one
+// stablecoin with its deviation history.
+func stablecoinHandler(w http.ResponseWriter, r *http.Request) {
+	ref := strings.TrimPrefix(r.URL.Path, "/api/stablecoins/")
+
+	p, format, err := clientParams(r)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	if !validCoinRef(ref) {
+		p.fail("coin", "must be a CoinMarketCap ID, slug or symbol")
+	}
+	from := p.timestamp("from")
+	to := p.timestamp("to")
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		p.fail("from", "must be before to")
+	}
+	if err := p.err(); err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	if !allowRequest(w, r, format) {
+		return
+	}
+
+	coin, ok := pegs.get(ref)
+	if !ok {
+		writeError(w, r, format, errNotFound("No stablecoin "+ref+" is tracked."))
+		return
+	}
+	coin.History = historyBetween(coin.History, func(point types.PegPoint) time.Time { return point.Time }, from, to)
+
+	if format == "json" {
+		writeJSON(w, http.StatusOK, coin)
+		return
+	}
+	coin.APIKey = p.str("api-key")
+	renderHTML(w, r, http.StatusOK, templates.Peg, coin)
+}
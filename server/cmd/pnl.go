@@ -0,0 +1,188 @@
+package main
+
+import (
+	"server/types"
+	"sort"
+)
+
+// costMethods are the lot matching methods of the P&L report.
+var costMethods = []string{"fifo", "lifo", "average"}
+
+// dust is the quantity below which a lot counts as used up; float sums of
+// exchange amounts rarely come out at exactly zero.
+const dust = 1e-12
+
+type lot struct {
+	quantity float64
+	cost     float64
+}
+
+// lots are the open purchases of one coin. With average cost there is a
+// single pooled lot, so selling takes the average cost per coin.
+type lots struct {
+	method string
+	open   []lot
+}
+
+func (l *lots) add(quantity, cost float64) {
+	if l.method == "average" && len(l.open) > 0 {
+		l.open[0].quantity += quantity
+		l.open[0].cost += cost
+		return
+	}
+	l.open = append(l.open, lot{quantity: quantity, cost: cost})
+}
+
+// take removes quantity, oldest lot first for fifo and newest first for
+// lifo. It returns the cost basis removed and the part of quantity no lot
+// covered.
+func (l *lots) take(quantity float64) (cost, unmatched float64) {
+	for quantity > dust && len(l.open) > 0 {
+		i := 0
+		if l.method == "lifo" {
+			i = len(l.open) - 1
+		}
+
+		lt := &l.open[i]
+		if lt.quantity <= quantity+dust {
+			cost += lt.cost
+			quantity -= lt.quantity
+			l.open = append(l.open[:i], l.open[i+1:]...)
+			continue
+		}
+
+		part := lt.cost * quantity / lt.quantity
+		cost += part
+		lt.cost -= part
+		lt.quantity -= quantity
+		quantity = 0
+	}
+
+	if quantity > dust {
+		unmatched = quantity
+	}
+	return cost, unmatched
+}
+
+func (l *lots) totals() (quantity, cost float64) {
+	for _, lt := range l.open {
+		quantity += lt.quantity
+		cost += lt.cost
+	}
+	return quantity, cost
+}
+
+// computePnL replays txs in time order. Buy fees are part of the cost
+// basis and sell fees reduce the proceeds. A transfer out takes its lots
+// out of the ledger without realizing anything, only its fee is a realized
+// loss. Open positions are valued at the listings in byID.
+func computePnL(txs []types.Transaction, method string, byID map[int]types.CryptoListing) types.PnLReport {
+	sorted := append([]types.Transaction{}, txs...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+
+	type position struct {
+		pnl  types.CoinPnL
+		lots lots
+	}
+	// coins sharing a ticker are kept apart by their ID, only coins that
+	// were never resolved go by symbol
+	type positionKey struct {
+		coinID int
+		symbol string
+	}
+	positions := make(map[positionKey]*position)
+	years := make(map[int]*types.YearGains)
+
+	for _, tx := range sorted {
+		key := positionKey{coinID: tx.CoinID}
+		if tx.CoinID == 0 {
+			key.symbol = tx.Symbol
+		}
+		pos, ok := positions[key]
+		if !ok {
+			pos = &position{
+				pnl:  types.CoinPnL{Symbol: tx.Symbol},
+				lots: lots{method: method},
+			}
+			positions[key] = pos
+		}
+		if tx.CoinID != 0 {
+			pos.pnl.CoinID = tx.CoinID
+			pos.pnl.Name = tx.Name
+		}
+		pos.pnl.Fees += tx.Fee
+
+		year, ok := years[tx.Time.Year()]
+		if !ok && (tx.Type == "sell" || tx.Type == "transfer_out") {
+			year = &types.YearGains{Year: tx.Time.Year()}
+			years[year.Year] = year
+		}
+
+		switch tx.Type {
+		case "buy", "transfer_in":
+			pos.lots.add(tx.Quantity, tx.Quantity*tx.Price+tx.Fee)
+
+		case "sell":
+			cost, unmatched := pos.lots.take(tx.Quantity)
+			proceeds := tx.Quantity*tx.Price - tx.Fee
+			pos.pnl.RealizedPnL += proceeds - cost
+			pos.pnl.Unmatched += unmatched
+
+			year.Sells++
+			year.Proceeds += proceeds
+			year.CostBasis += cost
+			year.Fees += tx.Fee
+			year.RealizedPnL += proceeds - cost
+
+		case "transfer_out":
+			_, unmatched := pos.lots.take(tx.Quantity)
+			pos.pnl.RealizedPnL -= tx.Fee
+			pos.pnl.Unmatched += unmatched
+
+			year.Fees += tx.Fee
+			year.RealizedPnL -= tx.Fee
+		}
+	}
+
+	report := types.PnLReport{
+		Method:       method,
+		Coins:        make([]types.CoinPnL, 0, len(positions)),
+		Years:        make([]types.YearGains, 0, len(years)),
+		Transactions: sorted,
+	}
+
+	for _, pos := range positions {
+		c := pos.pnl
+		c.Quantity, c.CostBasis = pos.lots.totals()
+
+		if quote, ok := byID[c.CoinID].Quote["USD"]; ok {
+			c.Priced = true
+			c.Price = quote.Price
+			c.Value = c.Quantity * quote.Price
+			c.UnrealizedPnL = c.Value - c.CostBasis
+		}
+
+		report.Value += c.Value
+		report.CostBasis += c.CostBasis
+		report.RealizedPnL += c.RealizedPnL
+		report.UnrealizedPnL += c.UnrealizedPnL
+		report.Fees += c.Fees
+		report.Coins = append(report.Coins, c)
+	}
+	sort.Slice(report.Coins, func(i, j int) bool {
+		if report.Coins[i].Value != report.Coins[j].Value {
+			return report.Coins[i].Value > report.Coins[j].Value
+		}
+		if report.Coins[i].Symbol != report.Coins[j].Symbol {
+			return report.Coins[i].Symbol < report.Coins[j].Symbol
+		}
+		return report.Coins[i].CoinID < report.Coins[j].CoinID
+	})
+
+	for _, y := range years {
+		report.Years = append(report.Years, *y)
+	}
+	sort.Slice(report.Years, func(i, j int) bool { return report.Years[i].Year < report.Years[j].Year })
+
+	return report
+}
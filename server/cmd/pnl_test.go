@@ -0,0 +1,135 @@
+package main
+
+import (
+	"reflect"
+	"server/types"
+	"testing"
+	"time"
+)
+
+func TestComputePnL(t *testing.T) {
+	tx := func(date, typ, symbol string, coinID int, quantity, price, fee float64) types.Transaction {
+		at, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return types.Transaction{Time: at, Type: typ, Symbol: symbol, CoinID: coinID, Quantity: quantity, Price: price, Fee: fee}
+	}
+	twoBuysOneSell := []types.Transaction{
+		tx("2024-01-01", "buy", "BTC", 1, 1, 100, 0),
+		tx("2024-01-02", "buy", "BTC", 1, 1, 200, 0),
+		tx("2024-01-03", "sell", "BTC", 1, 1, 300, 0),
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		txs    []types.Transaction
+		coins  []types.CoinPnL
+		years  []types.YearGains
+	}{
+		{
+			name:   "fifo sells the oldest lot",
+			method: "fifo",
+			txs:    twoBuysOneSell,
+			coins:  []types.CoinPnL{{Symbol: "BTC", CoinID: 1, Quantity: 1, CostBasis: 200, RealizedPnL: 200}},
+			years:  []types.YearGains{{Year: 2024, Sells: 1, Proceeds: 300, CostBasis: 100, RealizedPnL: 200}},
+		},
+		{
+			name:   "lifo sells the newest lot",
+			method: "lifo",
+			txs:    twoBuysOneSell,
+			coins:  []types.CoinPnL{{Symbol: "BTC", CoinID: 1, Quantity: 1, CostBasis: 100, RealizedPnL: 100}},
+			years:  []types.YearGains{{Year: 2024, Sells: 1, Proceeds: 300, CostBasis: 200, RealizedPnL: 100}},
+		},
+		{
+			name:   "average pools the lots",
+			method: "average",
+			txs:    twoBuysOneSell,
+			coins:  []types.CoinPnL{{Symbol: "BTC", CoinID: 1, Quantity: 1, CostBasis: 150, RealizedPnL: 150}},
+			years:  []types.YearGains{{Year: 2024, Sells: 1, Proceeds: 300, CostBasis: 150, RealizedPnL: 150}},
+		},
+		{
+			name:   "a sell takes part of a lot",
+			method: "fifo",
+			txs: []types.Transaction{
+				tx("2024-01-01", "buy", "ETH", 2, 2, 100, 0),
+				tx("2024-01-02", "sell", "ETH", 2, 0.5, 200, 0),
+			},
+			coins: []types.CoinPnL{{Symbol: "ETH", CoinID: 2, Quantity: 1.5, CostBasis: 150, RealizedPnL: 50}},
+			years: []types.YearGains{{Year: 2024, Sells: 1, Proceeds: 100, CostBasis: 50, RealizedPnL: 50}},
+		},
+		{
+			name:   "selling more than is held leaves the rest unmatched",
+			method: "fifo",
+			txs: []types.Transaction{
+				tx("2024-01-01", "buy", "BTC", 1, 1, 100, 0),
+				tx("2024-01-02", "sell", "BTC", 1, 3, 100, 0),
+			},
+			coins: []types.CoinPnL{{Symbol: "BTC", CoinID: 1, RealizedPnL: 200, Unmatched: 2}},
+			years: []types.YearGains{{Year: 2024, Sells: 1, Proceeds: 300, CostBasis: 100, RealizedPnL: 200}},
+		},
+		{
+			name:   "buy fees add to the cost basis, sell fees reduce the proceeds",
+			method: "fifo",
+			txs: []types.Transaction{
+				tx("2024-01-01", "buy", "BTC", 1, 1, 100, 10),
+				tx("2024-01-02", "sell", "BTC", 1, 1, 150, 5),
+			},
+			coins: []types.CoinPnL{{Symbol: "BTC", CoinID: 1, RealizedPnL: 35, Fees: 15}},
+			years: []types.YearGains{{Year: 2024, Sells: 1, Proceeds: 145, CostBasis: 110, Fees: 5, RealizedPnL: 35}},
+		},
+		{
+			name:   "a transfer out removes lots and realizes only its fee",
+			method: "fifo",
+			txs: []types.Transaction{
+				tx("2024-01-01", "buy", "BTC", 1, 2, 100, 0),
+				tx("2024-01-02", "transfer_out", "BTC", 1, 1, 0, 2),
+			},
+			coins: []types.CoinPnL{{Symbol: "BTC", CoinID: 1, Quantity: 1, CostBasis: 100, RealizedPnL: -2, Fees: 2}},
+			years: []types.YearGains{{Year: 2024, Fees: 2, RealizedPnL: -2}},
+		},
+		{
+			name:   "realized gains are totalled per year",
+			method: "fifo",
+			txs: []types.Transaction{
+				tx("2024-03-01", "sell", "BTC", 1, 1, 50, 0),
+				tx("2023-01-01", "buy", "BTC", 1, 2, 100, 0),
+				tx("2023-06-01", "sell", "BTC", 1, 1, 150, 0),
+			},
+			coins: []types.CoinPnL{{Symbol: "BTC", CoinID: 1, RealizedPnL: 0}},
+			years: []types.YearGains{
+				{Year: 2023, Sells: 1, Proceeds: 150, CostBasis: 100, RealizedPnL: 50},
+				{Year: 2024, Sells: 1, Proceeds: 50, CostBasis: 100, RealizedPnL: -50},
+			},
+		},
+		{
+			name:   "coins sharing a ticker keep their own lots",
+			method: "fifo",
+			txs: []types.Transaction{
+				tx("2024-01-01", "buy", "UNI", 7083, 1, 100, 0),
+				tx("2024-01-02", "buy", "UNI", 9999, 1, 10, 0),
+				tx("2024-01-03", "sell", "UNI", 9999, 1, 20, 0),
+				tx("2024-01-04", "buy", "UNI", 0, 1, 1, 0),
+			},
+			coins: []types.CoinPnL{
+				{Symbol: "UNI", Quantity: 1, CostBasis: 1},
+				{Symbol: "UNI", CoinID: 7083, Quantity: 1, CostBasis: 100},
+				{Symbol: "UNI", CoinID: 9999, RealizedPnL: 10},
+			},
+			years: []types.YearGains{{Year: 2024, Sells: 1, Proceeds: 20, CostBasis: 10, RealizedPnL: 10}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := computePnL(tt.txs, tt.method, nil)
+			if !reflect.DeepEqual(report.Coins, tt.coins) {
+				t.Errorf("coins = %+v, want %+v", report.Coins, tt.coins)
+			}
+			if !reflect.DeepEqual(report.Years, tt.years) {
+				t.Errorf("years = %+v, want %+v", report.Years, tt.years)
+			}
+		})
+	}
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	templates "server/html"
+	"server/types"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// portfolioStore keeps the holdings of every client in one JSON file under
+// cfg.DataDir, keyed by ownerID. It is small and written on every change.
+type portfolioStore struct {
+	mu       sync.Mutex
+	path     string
+	holdings map[string][]types.Holding
+}
+
+var portfolios *portfolioStore
+
+func openPortfolioStore(dir string) (*portfolioStore, error) {
+	s := &portfolioStore{
+		path:     filepath.Join(dir, "portfolios.json"),
+		holdings: make(map[string][]types.Holding),
+	}
+	if err := readJSONFile(s.path, &s.holdings); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// flush writes the holdings on shutdown. Every change is already written,
+// a failed one rolled back, so this only guards the file.
+func (s *portfolioStore) flush(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return writeJSONFile(s.path, s.holdings)
+}
+
+// list returns a copy of the holdings of apiKey in the order they were
+// added.
+func (s *portfolioStore) list(apiKey string) []types.Holding {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]types.Holding(nil), s.holdings[ownerID(apiKey)]...)
+}
+
+func (s *portfolioStore) add(apiKey string, h types.Holding) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	owner := ownerID(apiKey)
+	prev := s.holdings[owner]
+	s.holdings[owner] = append(prev[:len(prev):len(prev)], h)
+	if err := writeJSONFile(s.path, s.holdings); err != nil {
+		s.holdings[owner] = prev
+		return err
+	}
+	return nil
+}
+
+// remove deletes one holding and reports whether it existed.
+func (s *portfolioStore) remove(apiKey, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	owner := ownerID(apiKey)
+	prev := s.holdings[owner]
+	kept := make([]types.Holding, 0, len(prev))
+	for _, h := range prev {
+		if h.ID != id {
+			kept = append(kept, h)
+		}
+	}
+	if len(kept) == len(prev) {
+		return false, nil
+	}
+
+	if len(kept) == 0 {
+		delete(s.holdings, owner)
+	} else {
+		s.holdings[owner] = kept
+	}
+	if err := writeJSONFile(s.path, s.holdings); err != nil {
+		s.holdings[owner] = prev
+		return false, err
+	}
+	return true, nil
+}
+
+// portfolioHandler serves /api/portfolio, the holdings of the caller valued
+// at the latest quotes. POST is accepted so the HTML forms can send the
+// api-key in the body.
+func portfolioHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeError(w, r, preferredFormat(r), errMethodNotAllowed(http.MethodGet, http.MethodPost))
+		return
+	}
+
+	p, format, err := clientParams(r)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+	if err := p.err(); err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	if !allowRequest(w, r, format) {
+		return
+	}
+
+	apiKey := p.str("api-key")
+	value, err := currentPortfolio(r, apiKey)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	if format == "json" {
+		writeJSON(w, http.StatusOK, value)
+		return
+	}
+	renderHTML(w, r, http.StatusOK, templates.Portfolio, value)
+}
+
+// holdingsHandler serves POST /api/portfolio/holdings, which adds a
+// holding. The coin is resolved against the cached listings, so only coins
+// that can be valued are accepted.
+func holdingsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, preferredFormat(r), errMethodNotAllowed(http.MethodPost))
+		return
+	}
+
+	p, format, err := clientParams(r)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	ref := p.str("coin")
+	if ref == "" {
+		p.fail("coin", "is required")
+	} else if !validCoinRef(ref) {
+		p.fail("coin", "must be a CoinMarketCap ID, slug or symbol")
+	}
+	quantity := p.number("quantity")
+	if quantity == nil {
+		p.fail("quantity", "is required")
+	} else if *quantity == 0 {
+		p.fail("quantity", "must be greater than 0")
+	}
+	costBasis := p.number("cost_basis")
+	if costBasis == nil {
+		p.fail("cost_basis", "is required")
+	}
+	acquired := p.date("acquired", false)
+	if err := p.err(); err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	if !allowRequest(w, r, format) {
+		return
+	}
+
+	listings, err := latestListings.get(r.Context(), upstreamClient)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+	coin, ok := findListing(listings, ref)
+	if !ok {
+		p.fail("coin", "not among the top %d coins by market cap", cfg.QuoteListingLimit)
+		writeError(w, r, format, p.err())
+		return
+	}
+
+	if acquired.IsZero() {
+		acquired = time.Now().UTC()
+	}
+	id, err := newID()
+	if err != nil {
+		writeError(w, r, format, errInternal(err))
+		return
+	}
+	h := types.Holding{
+		ID:        id,
+		CoinID:    coin.ID,
+		Name:      coin.Name,
+		Symbol:    coin.Symbol,
+		Slug:      coin.Slug,
+		Quantity:  *quantity,
+		CostBasis: *costBasis,
+		Acquired:  acquired.Format(time.DateOnly),
+	}
+
+	apiKey := p.str("api-key")
+	if err := portfolios.add(apiKey, h); err != nil {
+		writeError(w, r, format, errInternal(err))
+		return
+	}
+
+	value := valuePortfolio(portfolios.list(apiKey), listingsByID(listings))
+	value.APIKey = apiKey
+
+	w.Header().Set("Location", "/api/portfolio/holdings/"+h.ID)
+	if format == "json" {
+		hv, _ := findHolding(value, h.ID)
+		writeJSON(w, http.StatusCreated, hv)
+		return
+	}
+	renderHTML(w, r, http.StatusCreated, templates.Portfolio, value)
+}
+
+// holdingHandler serves /api/portfolio/holdings/{holding}: GET and POST
+// show one valued holding, DELETE removes it.
+func holdingHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		writeError(w, r, preferredFormat(r), errMethodNotAllowed(http.MethodGet, http.MethodPost, http.MethodDelete))
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/portfolio/holdings/")
+
+	p, format, err := clientParams(r)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+	if err := p.err(); err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	if !allowRequest(w, r, format) {
+		return
+	}
+
+	apiKey := p.str("api-key")
+	notFound := errNotFound("No holding with ID " + id + " in this portfolio.")
+
+	if r.Method == http.MethodDelete {
+		removed, err := portfolios.remove(apiKey, id)
+		if err != nil {
+			writeError(w, r, format, errInternal(err))
+			return
+		}
+		if !removed {
+			writeError(w, r, format, notFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	value, err := currentPortfolio(r, apiKey)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+	hv, ok := findHolding(value, id)
+	if !ok {
+		writeError(w, r, format, notFound)
+		return
+	}
+
+	if format == "json" {
+		writeJSON(w, http.StatusOK, hv)
+		return
+	}
+	renderHTML(w, r, http.StatusOK, templates.Holding, hv)
+}
+
+// currentPortfolio values the holdings of apiKey at the cached quotes. An
+// empty portfolio is answered without touching the listings.
+func currentPortfolio(r *http.Request, apiKey string) (types.PortfolioValue, error) {
+	holdings := portfolios.list(apiKey)
+
+	var byID map[int]types.CryptoListing
+	if len(holdings) > 0 {
+		listings, err := latestListings.get(r.Context(), upstreamClient)
+		if err != nil {
+			return types.PortfolioValue{}, err
+		}
+		byID = listingsByID(listings)
+	}
+
+	value := valuePortfolio(holdings, byID)
+	value.APIKey = apiKey
+	return value, nil
+}
+
+func findHolding(value types.PortfolioValue, id string) (types.HoldingValue, bool) {
+	for _, hv := range value.Holdings {
+		if hv.ID == id {
+			hv.APIKey = value.APIKey
+			return hv, true
+		}
+	}
+	return types.HoldingValue{}, false
+}
+
+// valuePortfolio values every holding at its USD quote, largest position
+// first. Holdings without a quote are counted in Unpriced and left out of
+// the totals, so the P&L only compares what could be valued.
+func valuePortfolio(holdings []types.Holding, byID map[int]types.CryptoListing) types.PortfolioValue {
+	value := types.PortfolioValue{Holdings: make([]types.HoldingValue, 0, len(holdings))}
+
+	for _, h := range holdings {
+		hv := valueHolding(h, byID)
+		if hv.Priced {
+			value.TotalValue += hv.Value
+			value.TotalCost += h.CostBasis
+			value.Change24h += hv.Change24h
+			value.Change7d += hv.Change7d
+		} else {
+			value.Unpriced++
+		}
+		value.Holdings = append(value.Holdings, hv)
+	}
+
+	for i := range value.Holdings {
+		value.Holdings[i].Allocation = percentOf(value.Holdings[i].Value, value.TotalValue)
+	}
+	sort.SliceStable(value.Holdings, func(i, j int) bool {
+		return value.Holdings[i].Value > value.Holdings[j].Value
+	})
+
+	value.PercentChange24h = percentOf(value.Change24h, value.TotalValue-value.Change24h)
+	value.PercentChange7d = percentOf(value.Change7d, value.TotalValue-value.Change7d)
+	value.UnrealizedPnL = value.TotalValue - value.TotalCost
+	value.UnrealizedPnLPercent = percentOf(value.UnrealizedPnL, value.TotalCost)
+	return value
+}
+
+func valueHolding(h types.Holding, byID map[int]types.CryptoListing) types.HoldingValue {
+	hv := types.HoldingValue{Holding: h}
+
+	quote, ok := byID[h.CoinID].Quote["USD"]
+	if !ok {
+		return hv
+	}
+
+	hv.Priced = true
+	hv.Price = quote.Price
+	hv.Value = h.Quantity * quote.Price
+	hv.PercentChange24h = quote.PercentChange24h
+	hv.Change24h = changeOver(hv.Value, quote.PercentChange24h)
+	hv.PercentChange7d = quote.PercentChange7d
+	hv.Change7d = changeOver(hv.Value, quote.PercentChange7d)
+	hv.UnrealizedPnL = hv.Value - h.CostBasis
+	hv.UnrealizedPnLPercent = percentOf(hv.UnrealizedPnL, h.CostBasis)
+	return hv
+}
+
+// changeOver is the absolute change behind a percent change: value was
+// value / (1 + percent/100) at the start of the period.
+func changeOver(value, percent float64) float64 {
+	if percent <= -100 {
+		return value
+	}
+	return value - value/(1+percent/100)
+}
+
+// percentOf is part as a percentage of whole, zero when whole is zero.
+func percentOf(part, whole float64) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return part / whole * 100
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"server/types"
+	"testing"
+)
+
+func TestPortfolioStoreSeparatesKeys(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openPortfolioStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.add("alice-key", types.Holding{ID: "h1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := openPortfolioStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.list("alice-key"); len(got) != 1 || got[0].ID != "h1" {
+		t.Errorf("list(alice-key) = %+v, want the one holding", got)
+	}
+	if got := reopened.list("bob-key"); len(got) != 0 {
+		t.Errorf("list(bob-key) = %+v, want none", got)
+	}
+	if removed, err := reopened.remove("bob-key", "h1"); err != nil || removed {
+		t.Errorf("remove(bob-key, h1) = %v, %v, want another key's holding left alone", removed, err)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	templates "server/html"
+	"strconv"
+	"strings"
+)
+
+// Error codes returned in the code member of a problem. Clients should
+// branch on these, titles and details are for humans.
+const (
+	codeInvalidAPIKey       = "invalid_api_key"
+	codeInvalidParameters   = "invalid_parameters"
+	codeRateLimited         = "rate_limited"
+	codeUpstreamUnavailable = "upstream_unavailable"
+	codeUpstreamError       = "upstream_error"
+	codeUpstreamBadResponse = "upstream_bad_response"
+	codeNotFound            = "not_found"
+	codeMethodNotAllowed    = "method_not_allowed"
+	codeInternal            = "internal_error"
+)
+
+// apiError is the single error type handlers answer with. It is rendered as
+// application/problem+json (RFC 7807) for API clients and as an HTML page
+// for browsers.
+type apiError struct {
+	Status int
+	Code   string
+	Title  string
+	Detail string
+
+	// Provider and UpstreamStatus are set when a data provider failed
+	Provider       string
+	UpstreamStatus int
+
+	// Fields lists invalid parameters for codeInvalidParameters
+	Fields []fieldError
+
+	// RetryAfter is sent as the Retry-After header when set, in seconds
+	RetryAfter int
+
+	// Allow is sent as the Allow header of a 405
+	Allow []string
+
+	// Err is the underlying cause; it is logged but never shown to clients
+	Err error
+}
+
+func (e *apiError) Error() string {
+	msg := e.Code + ": " + e.Detail
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *apiError) Unwrap() error {
+	return e.Err
+}
+
+// problem is the JSON body of an error response.
+type problem struct {
+	Type           string       `json:"type"`
+	Title          string       `json:"title"`
+	Status         int          `json:"status"`
+	Detail         string       `json:"detail,omitempty"`
+	Instance       string       `json:"instance,omitempty"`
+	Code           string       `json:"code"`
+	RequestID      string       `json:"request_id,omitempty"`
+	Provider       string       `json:"provider,omitempty"`
+	UpstreamStatus int          `json:"upstream_status,omitempty"`
+	Fields         []fieldError `json:"fields,omitempty"`
+}
+
+func errInvalidAPIKey() *apiError {
+	return &apiError{
+		Status: http.StatusUnauthorized,
+		Code:   codeInvalidAPIKey,
+		Title:  "Invalid API key",
+		Detail: "The api-key parameter is missing or not recognised.",
+	}
+}
+
+func errRateLimited() *apiError {
+	return &apiError{
+		Status:     http.StatusTooManyRequests,
+		Code:       codeRateLimited,
+		Title:      "Rate limit exceeded",
+		Detail:     "Too many requests from this client, slow down and retry.",
+		RetryAfter: 1,
+	}
+}
+
+func errNotFound(detail string) *apiError {
+	return &apiError{
+		Status: http.StatusNotFound,
+		Code:   codeNotFound,
+		Title:  "Not found",
+		Detail: detail,
+	}
+}
+
+func errMethodNotAllowed(allowed ...string) *apiError {
+	return &apiError{
+		Status: http.StatusMethodNotAllowed,
+		Code:   codeMethodNotAllowed,
+		Title:  "Method not allowed",
+		Detail: "Use one of: " + strings.Join(allowed, ", ") + ".",
+		Allow:  allowed,
+	}
+}
+
+func errInternal(err error) *apiError {
+	return &apiError{
+		Status: http.StatusInternalServerError,
+		Code:   codeInternal,
+		Title:  "Internal server error",
+		Detail: "Something went wrong on our side.",
+		Err:    err,
+	}
+}
+
+// errUpstreamUnavailable is a provider that could not be reached at all.
+func errUpstreamUnavailable(provider string, err error) *apiError {
+	return &apiError{
+		Status:   http.StatusBadGateway,
+		Code:     codeUpstreamUnavailable,
+		Title:    "Data provider unavailable",
+		Detail:   "Could not reach " + provider + ".",
+		Provider: provider,
+		Err:      err,
+	}
+}
+
+// errUpstreamStatus is a provider that answered with a non-OK status.
+func errUpstreamStatus(provider string, status int) *apiError {
+	return &apiError{
+		Status:         http.StatusBadGateway,
+		Code:           codeUpstreamError,
+		Title:          "Data provider error",
+		Detail:         provider + " answered with status " + strconv.Itoa(status) + ".",
+		Provider:       provider,
+		UpstreamStatus: status,
+	}
+}
+
+// errUpstreamBadResponse is a provider response we could not decode.
+func errUpstreamBadResponse(provider string, err error) *apiError {
+	return &apiError{
+		Status:   http.StatusBadGateway,
+		Code:     codeUpstreamBadResponse,
+		Title:    "Invalid data provider response",
+		Detail:   "The response from " + provider + " could not be decoded.",
+		Provider: provider,
+		Err:      err,
+	}
+}
+
+// toAPIError maps any error to an apiError; unknown errors are internal.
+func toAPIError(err error) *apiError {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	var verr *validationError
+	if errors.As(err, &verr) {
+		return &apiError{
+			Status: http.StatusBadRequest,
+			Code:   codeInvalidParameters,
+			Title:  "Invalid parameters",
+			Detail: "One or more parameters are invalid, see fields.",
+			Fields: verr.Fields,
+		}
+	}
+
+	return errInternal(err)
+}
+
+// writeError renders err as problem+json when format is json and as the
+// HTML error page otherwise. Server side failures are logged with their
+// cause.
+func writeError(w http.ResponseWriter, r *http.Request, format string, err error) {
+	apiErr := toAPIError(err)
+
+	attrs := []any{"code", apiErr.Code, "status", apiErr.Status}
+	if apiErr.Provider != "" {
+		attrs = append(attrs, "provider", apiErr.Provider)
+	}
+	if apiErr.Err != nil {
+		attrs = append(attrs, "err", apiErr.Err)
+	}
+	if apiErr.Status >= 500 {
+		slog.ErrorContext(r.Context(), apiErr.Title, attrs...)
+	} else {
+		slog.InfoContext(r.Context(), apiErr.Title, attrs...)
+	}
+
+	p := problem{
+		Type:           "urn:cryptosummary:error:" + apiErr.Code,
+		Title:          apiErr.Title,
+		Status:         apiErr.Status,
+		Detail:         apiErr.Detail,
+		Instance:       r.URL.Path,
+		Code:           apiErr.Code,
+		RequestID:      requestIDFrom(r.Context()),
+		Provider:       apiErr.Provider,
+		UpstreamStatus: apiErr.UpstreamStatus,
+		Fields:         apiErr.Fields,
+	}
+
+	if apiErr.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(apiErr.RetryAfter))
+	}
+	if len(apiErr.Allow) > 0 {
+		w.Header().Set("Allow", strings.Join(apiErr.Allow, ", "))
+	}
+
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(p.Status)
+		if err := json.NewEncoder(w).Encode(p); err != nil {
+			slog.ErrorContext(r.Context(), "Error encoding problem response", "err", err)
+		}
+		return
+	}
+
+	tmpl, err := template.New("").Parse(templates.Error)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error parsing template", "err", err)
+		http.Error(w, p.Title, p.Status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(p.Status)
+	if err := tmpl.Execute(w, p); err != nil {
+		slog.ErrorContext(r.Context(), "Error executing template", "err", err)
+	}
+}
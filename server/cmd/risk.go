@@ -0,0 +1,208 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	templates "server/html"
+	"server/types"
+	"time"
+)
+
+// benchmarkID is the coin betas are measured against, Bitcoin on
+// CoinMarketCap.
+const (
+	benchmarkID     = 1
+	benchmarkSymbol = "BTC"
+)
+
+const defaultRiskWindow = 30
+
+// riskHandler serves /api/risk?coin=&interval=. Metrics are computed from
+// the candles of /api/candles with missing candles filled with the previous
+// close; the rolling volatility reads window candles before from so it is
+// defined from the start of the range.
+func riskHandler(w http.ResponseWriter, r *http.Request) {
+	p, format, err := clientParams(r)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	ref := p.str("coin")
+	if ref == "" {
+		p.fail("coin", "is required")
+	} else if !validCoinRef(ref) {
+		p.fail("coin", "must be a CoinMarketCap ID, slug or symbol")
+	}
+	interval := p.enum("interval", "1d", candleIntervalNames(), false)
+	currency := snapshotCurrency(p)
+	from, to := candleRange(p, interval)
+	window := p.integer("window", defaultRiskWindow, 2, maxIndicatorWindow, false)
+	riskFree := riskFreeRate(p)
+	if err := p.err(); err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	if !allowRequest(w, r, format) {
+		return
+	}
+
+	coin, err := snapshotCoin(ref)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	size := candleIntervalSize(interval)
+	filled := filledCandles(interval, currency, coin.ID, from.Add(-time.Duration(window)*size), to)
+	first := len(filled)
+	for i, c := range filled {
+		if !c.Time.Before(from) {
+			first = i
+			break
+		}
+	}
+	benchmark := filledCandles(interval, currency, benchmarkID, from, to)
+
+	report := types.RiskReport{
+		ID:                coin.ID,
+		Name:              coin.Name,
+		Symbol:            coin.Symbol,
+		Slug:              coin.Slug,
+		Currency:          currency,
+		Interval:          interval,
+		From:              from,
+		To:                to,
+		Window:            window,
+		RiskFreeRate:      riskFree,
+		Benchmark:         benchmarkSymbol,
+		Metrics:           riskMetrics(filled[first:], benchmark, interval, riskFree),
+		RollingVolatility: []types.RiskPoint{},
+	}
+
+	// the return of a candle is its change from the candle before, so the
+	// rolling volatility of candle i is at index i-1
+	rolling := CalculateRollingVolatility(CalculateReturns(candleCloses(filled)), window, periodsPerYear(interval))
+	for i := first; i < len(filled); i++ {
+		point := types.RiskPoint{Time: filled[i].Time, Close: filled[i].Close}
+		if i > 0 {
+			point.Volatility = finite(100 * rolling[i-1])
+		}
+		report.RollingVolatility = append(report.RollingVolatility, point)
+	}
+
+	if format == "json" {
+		writeJSON(w, http.StatusOK, report)
+		return
+	}
+	renderHTML(w, r, http.StatusOK, templates.Risk, report)
+}
+
+// riskFreeRate reads risk_free, the yearly risk-free return as a fraction,
+// cfg.RiskFreeRate when absent.
+func riskFreeRate(p *params) float64 {
+	if rate := p.number("risk_free"); rate != nil {
+		if *rate >= 1 {
+			p.fail("risk_free", "must be a fraction below 1, e.g. 0.04 for 4%%")
+		}
+		return *rate
+	}
+	return cfg.RiskFreeRate
+}
+
+// listingRisk computes the risk metrics of listings from the USD candles
+// of interval in the defaultCandles intervals up to until.
+func listingRisk(listings []types.CryptoListing, interval string, riskFree float64, until time.Time) *types.ListingRisk {
+	size := candleIntervalSize(interval)
+	to := until.Truncate(size).Add(size)
+	from := to.Add(-defaultCandles * size)
+
+	risk := &types.ListingRisk{
+		Interval:     interval,
+		From:         from,
+		To:           to,
+		RiskFreeRate: riskFree,
+		Benchmark:    benchmarkSymbol,
+		Coins:        make(map[int]*types.RiskMetrics),
+	}
+	benchmark := filledCandles(interval, "USD", benchmarkID, from, to)
+	for _, l := range listings {
+		filled := filledCandles(interval, "USD", l.ID, from, to)
+		if len(filled) == 0 {
+			continue
+		}
+		m := riskMetrics(filled, benchmark, interval, riskFree)
+		risk.Coins[l.ID] = &m
+	}
+	return risk
+}
+
+// riskMetrics describes the closes of series. Beta uses the returns of the
+// candles both series have.
+func riskMetrics(series, benchmark []types.Candle, interval string, riskFree float64) types.RiskMetrics {
+	closes := candleCloses(series)
+	returns := CalculateReturns(closes)
+	m := types.RiskMetrics{Returns: len(returns)}
+	if len(returns) < 2 {
+		return m
+	}
+
+	perYear := periodsPerYear(interval)
+	// the risk-free return compounded over one candle
+	riskFreeReturn := math.Pow(1+riskFree, 1/perYear) - 1
+
+	m.Volatility = finite(100 * CalculateVolatility(returns, perYear))
+	m.Sharpe = finite(CalculateSharpe(returns, riskFreeReturn, perYear))
+	m.Sortino = finite(CalculateSortino(returns, riskFreeReturn, perYear))
+
+	drawdown, peak, trough, longest := CalculateMaxDrawdown(closes)
+	m.MaxDrawdown = finite(100 * drawdown)
+	if drawdown < 0 {
+		m.MaxDrawdownPeak = &series[peak].Time
+		m.MaxDrawdownTrough = &series[trough].Time
+	}
+	if longest > 0 {
+		duration := time.Duration(longest) * candleIntervalSize(interval)
+		m.DrawdownDuration = duration.String()
+		m.DrawdownDurationSeconds = int64(duration.Seconds())
+	}
+
+	benchmarkReturns := make(map[time.Time]float64, len(benchmark))
+	for i := 1; i < len(benchmark); i++ {
+		benchmarkReturns[benchmark[i].Time] = benchmark[i].Close/benchmark[i-1].Close - 1
+	}
+	var own, market []float64
+	for i, r := range returns {
+		if b, ok := benchmarkReturns[series[i+1].Time]; ok {
+			own = append(own, r)
+			market = append(market, b)
+		}
+	}
+	if len(own) >= 2 {
+		m.Beta = finite(CalculateBeta(own, market))
+	}
+	return m
+}
+
+// periodsPerYear is the number of candles of interval in a year; crypto
+// markets never close.
+func periodsPerYear(interval string) float64 {
+	return float64(365*24*time.Hour) / float64(candleIntervalSize(interval))
+}
+
+func candleCloses(series []types.Candle) []float64 {
+	closes := make([]float64, len(series))
+	for i, c := range series {
+		closes[i] = c.Close
+	}
+	return closes
+}
+
+// finite is v, nil when it is NaN or infinite.
+func finite(v float64) *float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return &v
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	templates "server/html"
+	"server/types"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// searchModes: search ranks exact, prefix, substring and fuzzy matches;
+// autocomplete only exact and prefix ones and answers with bare
+// suggestions.
+var searchModes = []string{"search", "autocomplete"}
+
+const (
+	maxSearchQuery = 64
+	maxSearchLimit = 50
+)
+
+// cmcMapIndex caches the CoinMarketCap ID map, every active coin with its
+// market cap rank, the same way coinIndex caches the CoinGecko list.
+type cmcMapIndex struct {
+	mu      sync.Mutex
+	entries []types.CMCMapEntry
+	fetched time.Time
+}
+
+var cmcMap = &cmcMapIndex{}
+
+func (c *cmcMapIndex) get(ctx context.Context, client *http.Client) ([]types.CMCMapEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries != nil && time.Since(c.fetched) < cfg.CoinIndexTTL {
+		cacheRequests.inc("cmc_map", "hit")
+		return c.entries, nil
+	}
+	cacheRequests.inc("cmc_map", "miss")
+
+	entries, err := createCoinMarketCapMapRequest(ctx, client)
+	if err != nil {
+		if c.entries != nil {
+			return c.entries, nil
+		}
+		return nil, err
+	}
+
+	c.entries = entries
+	c.fetched = time.Now()
+	return entries, nil
+}
+
+// cached returns the map from the last fetch and its time without
+// fetching, nil before the first fetch.
+func (c *cmcMapIndex) cached() ([]types.CMCMapEntry, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.entries, c.fetched
+}
+
+func createCoinMarketCapMapRequest(ctx context.Context, client *http.Client) ([]types.CMCMapEntry, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://pro-api.coinmarketcap.com/v1/cryptocurrency/map", nil)
+	if err != nil {
+		return nil, errInternal(err)
+	}
+
+	q := req.URL.Query()
+	q.Add("listing_status", "active")
+	q.Add("sort", "cmc_rank")
+	req.URL.RawQuery = q.Encode()
+
+	req.Header.Set("Accepts", "application/json")
+	req.Header.Add("X-CMC_PRO_API_KEY", cfg.CMCAPIKey)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errUpstreamUnavailable("coinmarketcap", err)
+	}
+	defer resp.Body.Close()
+
+	var responseData struct {
+		Status types.Status        `json:"status"`
+		Data   []types.CMCMapEntry `json:"data"`
+	}
+	decodeErr := json.NewDecoder(resp.Body).Decode(&responseData)
+
+	if apiErr := cmcError(ctx, resp.StatusCode, responseData.Status); apiErr != nil {
+		return nil, apiErr
+	}
+	if decodeErr != nil {
+		return nil, errUpstreamBadResponse("coinmarketcap", decodeErr)
+	}
+	return responseData.Data, nil
+}
+
+// searchHandler serves /api/search?q=. Matching is case-insensitive and
+// tolerates typos; results are ordered by match quality and then by market
+// cap rank.
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	p, format, err := clientParams(r)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	query := strings.ToLower(p.str("q"))
+	if query == "" {
+		p.fail("q", "is required")
+	} else if len(query) > maxSearchQuery {
+		p.fail("q", "at most %d characters", maxSearchQuery)
+	}
+	mode := p.enum("mode", "search", searchModes, false)
+	defLimit := 10
+	if mode == "autocomplete" {
+		defLimit = 8
+	}
+	limit := p.integer("limit", defLimit, 1, maxSearchLimit, false)
+	if err := p.err(); err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	// autocomplete counts too: on a cold cache every keystroke would fetch
+	// both coin lists
+	if !allowRequest(w, r, format) {
+		return
+	}
+
+	entries, err := searchEntries(r.Context())
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	matches := searchCoins(entries, query, mode == "autocomplete", limit)
+
+	if mode == "autocomplete" {
+		s := types.Suggestions{Query: query, Suggestions: make([]types.Suggestion, len(matches))}
+		for i, m := range matches {
+			s.Suggestions[i] = types.Suggestion{Label: m.Name + " (" + m.Symbol + ")", Value: m.Slug}
+			if m.Slug == "" {
+				s.Suggestions[i].Value = m.Symbol
+			}
+		}
+		writeJSON(w, http.StatusOK, s)
+		return
+	}
+
+	result := types.SearchResult{Query: query, Results: matches, Caller: types.Caller{APIKey: p.str("api-key")}}
+	if format == "json" {
+		writeJSON(w, http.StatusOK, result)
+		return
+	}
+	renderHTML(w, r, http.StatusOK, templates.Search, result)
+}
+
+// searchEntry is a coin with the lowercased fields it can be found by.
+type searchEntry struct {
+	coin   types.SearchMatch
+	fields [][2]string // field name, lowercased value
+}
+
+// searchIndex keeps the entries merged from the two coin lists, built again
+// only when either list was fetched anew.
+type searchIndex struct {
+	mu           sync.Mutex
+	entries      []searchEntry
+	cmcFetched   time.Time
+	geckoFetched time.Time
+}
+
+var coinSearch = &searchIndex{}
+
+// searchEntries returns the merged search entries, refreshing the coin
+// lists as their caches require. Either source may be missing, the search
+// fails only without both.
+func searchEntries(ctx context.Context) ([]searchEntry, error) {
+	var cmcErr, geckoErr error
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, cmcErr = cmcMap.get(ctx, upstreamClient)
+	}()
+	go func() {
+		defer wg.Done()
+		_, geckoErr = coinList.get(ctx, upstreamClient)
+	}()
+	wg.Wait()
+
+	if cmcErr != nil && geckoErr != nil {
+		return nil, cmcErr
+	}
+	if cmcErr != nil {
+		slog.WarnContext(ctx, "Searching without the coinmarketcap map", "err", cmcErr)
+	}
+	if geckoErr != nil {
+		slog.WarnContext(ctx, "Searching without the coingecko list", "err", geckoErr)
+	}
+
+	// the lists are read back with their fetch times, so the entries are
+	// keyed by exactly the lists they were built from
+	cmcEntries, cmcFetched := cmcMap.cached()
+	geckoCoins, geckoFetched := coinList.cached()
+
+	coinSearch.mu.Lock()
+	defer coinSearch.mu.Unlock()
+
+	if coinSearch.entries == nil || !coinSearch.cmcFetched.Equal(cmcFetched) || !coinSearch.geckoFetched.Equal(geckoFetched) {
+		coinSearch.entries = mergeSearchEntries(cmcEntries, geckoCoins)
+		coinSearch.cmcFetched, coinSearch.geckoFetched = cmcFetched, geckoFetched
+	}
+	return coinSearch.entries, nil
+}
+
+// mergeSearchEntries merges the CoinMarketCap map with the CoinGecko list.
+// A CoinGecko coin with the same symbol and name as a CoinMarketCap one is
+// the same coin; the others are added unranked.
+func mergeSearchEntries(cmcEntries []types.CMCMapEntry, geckoCoins []types.Coin) []searchEntry {
+	entries := make([]searchEntry, 0, len(cmcEntries)+len(geckoCoins))
+	byKey := make(map[string]int, len(cmcEntries))
+	for _, c := range cmcEntries {
+		key := strings.ToLower(c.Symbol) + "|" + strings.ToLower(c.Name)
+		if _, dup := byKey[key]; !dup {
+			byKey[key] = len(entries)
+		}
+		entries = append(entries, searchEntry{
+			coin: types.SearchMatch{Name: c.Name, Symbol: c.Symbol, Slug: c.Slug, CMCID: c.ID, Rank: c.Rank},
+			fields: [][2]string{
+				{"symbol", strings.ToLower(c.Symbol)},
+				{"name", strings.ToLower(c.Name)},
+				{"slug", c.Slug},
+			},
+		})
+	}
+
+	for _, g := range geckoCoins {
+		key := strings.ToLower(g.Symbol) + "|" + strings.ToLower(g.Name)
+		if i, ok := byKey[key]; ok {
+			entries[i].coin.CoinGeckoID = g.ID
+			entries[i].fields = append(entries[i].fields, [2]string{"coingecko_id", g.ID})
+			continue
+		}
+		entries = append(entries, searchEntry{
+			coin: types.SearchMatch{Name: g.Name, Symbol: strings.ToUpper(g.Symbol), CoinGeckoID: g.ID},
+			fields: [][2]string{
+				{"symbol", strings.ToLower(g.Symbol)},
+				{"name", strings.ToLower(g.Name)},
+				{"coingecko_id", g.ID},
+			},
+		})
+	}
+	return entries
+}
+
+// match kinds, best first
+const (
+	matchExact = iota
+	matchPrefix
+	matchSubstring
+	matchFuzzy
+)
+
+var matchNames = []string{"exact", "prefix", "substring", "fuzzy"}
+
+// searchCoins returns the best limit matches of query, which must already
+// be lowercased. prefixOnly restricts matching to exact and prefix matches.
+func searchCoins(entries []searchEntry, query string, prefixOnly bool, limit int) []types.SearchMatch {
+	type hit struct {
+		match types.SearchMatch
+		kind  int
+	}
+
+	maxDist := maxEditDistance(query)
+	var hits []hit
+	for _, e := range entries {
+		best := hit{kind: -1}
+		for _, f := range e.fields {
+			kind, dist, ok := matchField(query, f[1], prefixOnly, maxDist)
+			if !ok {
+				continue
+			}
+			if best.kind == -1 || kind < best.kind || kind == best.kind && dist < best.match.Distance {
+				best.kind = kind
+				best.match = e.coin
+				best.match.Match = matchNames[kind]
+				best.match.MatchedOn = f[0]
+				best.match.Distance = dist
+			}
+		}
+		if best.kind != -1 {
+			hits = append(hits, best)
+		}
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		a, b := hits[i], hits[j]
+		if a.kind != b.kind {
+			return a.kind < b.kind
+		}
+		if a.match.Distance != b.match.Distance {
+			return a.match.Distance < b.match.Distance
+		}
+		// ranked coins first, highest market cap first
+		if (a.match.Rank == 0) != (b.match.Rank == 0) {
+			return a.match.Rank != 0
+		}
+		if a.match.Rank != b.match.Rank {
+			return a.match.Rank < b.match.Rank
+		}
+		return len(a.match.Name) < len(b.match.Name)
+	})
+
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	matches := make([]types.SearchMatch, len(hits))
+	for i, h := range hits {
+		matches[i] = h.match
+	}
+	return matches
+}
+
+// maxEditDistance is the number of typos tolerated in query: none for very
+// short queries, where almost anything would match, one from four
+// characters and two from eight.
+func maxEditDistance(query string) int {
+	switch n := len([]rune(query)); {
+	case n >= 8:
+		return 2
+	case n >= 4:
+		return 1
+	}
+	return 0
+}
+
+func matchField(query, value string, prefixOnly bool, maxDist int) (kind, dist int, ok bool) {
+	switch {
+	case value == query:
+		return matchExact, 0, true
+	case strings.HasPrefix(value, query):
+		return matchPrefix, 0, true
+	case prefixOnly:
+		return 0, 0, false
+	case strings.Contains(value, query):
+		return matchSubstring, 0, true
+	case maxDist == 0:
+		return 0, 0, false
+	}
+
+	// compare against the whole value and against its beginning, so a typo
+	// in a partly typed name still matches
+	q, v := []rune(query), []rune(value)
+	dist = maxDist + 1
+	if abs(len(v)-len(q)) <= maxDist {
+		dist = levenshtein(q, v)
+	}
+	if len(v) > len(q) {
+		if d := levenshtein(q, v[:len(q)]); d < dist {
+			dist = d
+		}
+	}
+	if dist > maxDist {
+		return 0, 0, false
+	}
+	return matchFuzzy, dist, true
+}
+
+// levenshtein is the edit distance between a and b.
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
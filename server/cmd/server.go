@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type shutdownHook struct {
+	name string
+	fn   func(context.Context) error
+}
+
+var (
+	hooksMu       sync.Mutex
+	shutdownHooks []shutdownHook
+)
+
+// onShutdown registers fn to be called after the HTTP server has drained.
+// Background pollers, caches and stores use it to stop and flush their
+// state. Hooks run in reverse registration order.
+func onShutdown(name string, fn func(context.Context) error) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+
+	shutdownHooks = append(shutdownHooks, shutdownHook{name: name, fn: fn})
+}
+
+func runShutdownHooks(ctx context.Context) error {
+	hooksMu.Lock()
+	hooks := make([]shutdownHook, len(shutdownHooks))
+	copy(hooks, shutdownHooks)
+	hooksMu.Unlock()
+
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if err := hooks[i].fn(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("shutdown %s: %w", hooks[i].name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func newServer(cfg Config, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+}
+
+// run serves until the listener fails or SIGINT/SIGTERM is received, then
+// drains in-flight requests and runs the shutdown hooks. A second signal
+// skips what is left of cfg.ShutdownDrainDelay.
+func run(cfg Config, handler http.Handler) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := newServer(cfg, handler)
+
+	// bind before reporting that we are up, so a busy port is an error
+	// instead of a misleading log line
+	ln, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", cfg.Addr, err)
+	}
+	slog.Info("Server is running", "addr", ln.Addr().String())
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("serve: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+	}
+	// catch a second signal before giving up the first one, so an
+	// operator can cut the drain delay short
+	again := make(chan os.Signal, 1)
+	signal.Notify(again, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(again)
+	stop()
+
+	// report not ready while still serving, so the load balancer takes us
+	// out of rotation before connections are refused
+	shuttingDown.Store(true)
+	slog.Info("Shutting down, reporting not ready", "delay", cfg.ShutdownDrainDelay)
+	delay := time.NewTimer(cfg.ShutdownDrainDelay)
+	select {
+	case <-delay.C:
+	case sig := <-again:
+		delay.Stop()
+		slog.Warn("Received a second signal, skipping the drain delay", "signal", sig.String())
+	}
+
+	slog.Info("Draining in-flight requests")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	var errs []error
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		errs = append(errs, fmt.Errorf("drain: %w", err))
+	}
+	if err := runShutdownHooks(shutdownCtx); err != nil {
+		errs = append(errs, err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	slog.Info("Server stopped")
+	return nil
+}
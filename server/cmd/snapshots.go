@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"server/types"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snapshotFileLayout names snapshot files, so they sort by time and can be
+// listed without opening them.
+const (
+	snapshotFileLayout = "20060102T150405Z"
+	snapshotFileExt    = ".json.gz"
+)
+
+// loadedSnapshots bounds how many decoded snapshots are kept in memory.
+const loadedSnapshots = 8
+
+// snapshotStore keeps the market listing at regular points in time, one
+// gzipped JSON file per snapshot under cfg.DataDir/snapshots.
+type snapshotStore struct {
+	dir string
+
+	mu    sync.Mutex
+	times []time.Time // sorted, oldest first
+	cache map[time.Time]types.Snapshot
+	order []time.Time // cache keys, least recently loaded first
+}
+
+var snapshots *snapshotStore
+
+func openSnapshotStore(dir string) (*snapshotStore, error) {
+	s := &snapshotStore{
+		dir:   filepath.Join(dir, "snapshots"),
+		cache: make(map[time.Time]types.Snapshot),
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), snapshotFileExt)
+		if !ok || e.IsDir() {
+			continue
+		}
+		t, err := time.Parse(snapshotFileLayout, name)
+		if err != nil {
+			continue
+		}
+		s.times = append(s.times, t)
+	}
+	sort.Slice(s.times, func(i, j int) bool { return s.times[i].Before(s.times[j]) })
+	return s, nil
+}
+
+func (s *snapshotStore) path(t time.Time) string {
+	return filepath.Join(s.dir, t.UTC().Format(snapshotFileLayout)+snapshotFileExt)
+}
+
+// add stores snap and deletes the snapshots that fell out of
+// cfg.SnapshotRetention. Snapshot times have a resolution of one second.
+func (s *snapshotStore) add(snap types.Snapshot) error {
+	snap.Time = snap.Time.UTC().Truncate(time.Second)
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(snap); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := writeFileAtomic(s.path(snap.Time), buf.Bytes()); err != nil {
+		return err
+	}
+
+	i := sort.Search(len(s.times), func(i int) bool { return !s.times[i].Before(snap.Time) })
+	if i == len(s.times) || !s.times[i].Equal(snap.Time) {
+		s.times = append(s.times, time.Time{})
+		copy(s.times[i+1:], s.times[i:])
+		s.times[i] = snap.Time
+	}
+	s.remember(snap)
+
+	if cfg.SnapshotRetention > 0 {
+		s.prune(snap.Time.Add(-cfg.SnapshotRetention))
+	}
+	return nil
+}
+
+// prune deletes the snapshots taken before cutoff.
+func (s *snapshotStore) prune(cutoff time.Time) {
+	n := 0
+	for n < len(s.times) && s.times[n].Before(cutoff) {
+		if err := os.Remove(s.path(s.times[n])); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("Could not delete an expired snapshot", "time", s.times[n], "err", err)
+			break
+		}
+		s.forget(s.times[n])
+		n++
+	}
+	s.times = s.times[n:]
+}
+
+// forget drops the decoded snapshot taken at t from the cache.
+func (s *snapshotStore) forget(t time.Time) {
+	if _, ok := s.cache[t]; !ok {
+		return
+	}
+	delete(s.cache, t)
+	for i, o := range s.order {
+		if o.Equal(t) {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// nearest returns the time of the stored snapshot closest to t. Of two
+// equally close snapshots the older one wins.
+func (s *snapshotStore) nearest(t time.Time) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.times) == 0 {
+		return time.Time{}, false
+	}
+
+	i := sort.Search(len(s.times), func(i int) bool { return !s.times[i].Before(t) })
+	switch {
+	case i == 0:
+		return s.times[0], true
+	case i == len(s.times):
+		return s.times[i-1], true
+	case s.times[i].Sub(t) < t.Sub(s.times[i-1]):
+		return s.times[i], true
+	}
+	return s.times[i-1], true
+}
+
+// latest returns the time of the newest snapshot.
+func (s *snapshotStore) latest() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.times) == 0 {
+		return time.Time{}, false
+	}
+	return s.times[len(s.times)-1], true
+}
+
+// since returns the times of the snapshots taken after t, oldest first.
+func (s *snapshotStore) since(t time.Time) []time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := sort.Search(len(s.times), func(i int) bool { return s.times[i].After(t) })
+	return append([]time.Time{}, s.times[i:]...)
+}
+
+// load reads the snapshot taken at t, as returned by nearest.
+func (s *snapshotStore) load(t time.Time) (types.Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if snap, ok := s.cache[t]; ok {
+		return snap, nil
+	}
+
+	f, err := os.Open(s.path(t))
+	if err != nil {
+		return types.Snapshot{}, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return types.Snapshot{}, err
+	}
+	var snap types.Snapshot
+	if err := json.NewDecoder(zr).Decode(&snap); err != nil {
+		return types.Snapshot{}, err
+	}
+
+	s.remember(snap)
+	return snap, nil
+}
+
+// remember caches snap, dropping the least recently loaded snapshot when
+// the cache is full. Callers hold s.mu.
+func (s *snapshotStore) remember(snap types.Snapshot) {
+	if _, ok := s.cache[snap.Time]; !ok {
+		s.order = append(s.order, snap.Time)
+	}
+	s.cache[snap.Time] = snap
+
+	for len(s.order) > loadedSnapshots {
+		delete(s.cache, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// startSnapshotPoller stores a snapshot every cfg.SnapshotInterval until
+// shutdown. The first one is taken when the interval has passed since the
+// newest stored snapshot, so restarts do not add extra snapshots.
+func startSnapshotPoller() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		wait := time.Duration(0)
+		if t, ok := snapshots.latest(); ok {
+			wait = cfg.SnapshotInterval - time.Since(t)
+		}
+		timer := time.NewTimer(max(wait, 0))
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+			takeSnapshot(ctx)
+			timer.Reset(cfg.SnapshotInterval)
+		}
+	}()
+
+	onShutdown("snapshot poller", func(shutdownCtx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-shutdownCtx.Done():
+			return shutdownCtx.Err()
+		}
+	})
+}
+
+func takeSnapshot(parent context.Context) {
+	ctx, cancel := context.WithTimeout(parent, cfg.WriteTimeout)
+	defer cancel()
+
+	resp, err := fetchListings(ctx, upstreamClient, listingsQuery{
+		Start:   1,
+		Limit:   cfg.SnapshotLimit,
+		Order:   "market_cap",
+		SortDir: "desc",
+		Convert: cfg.SnapshotCurrencies,
+	})
+	if err != nil {
+		snapshotsTaken.inc("error")
+		// a shutdown cancels the request, that is not worth a warning
+		if parent.Err() == nil {
+			slog.Warn("Could not take a listings snapshot", "err", err)
+		}
+		return
+	}
+
+	// every store is updated from snap itself, so one failing does not
+	// hold back the others; the depeg check in particular keeps running
+	snap := types.Snapshot{Time: time.Now().UTC().Truncate(time.Second), Status: resp.Status, Listings: resp.Data}
+	failed := false
+	for _, store := range []struct {
+		name   string
+		update func() error
+	}{
+		{"snapshots", func() error { return snapshots.add(snap) }},
+		{"candles", func() error { return candles.add(snap) }},
+		{"indices", func() error { return indices.update(snap) }},
+		{"pegs", func() error { return pegs.update(ctx, snap) }},
+	} {
+		if err := store.update(); err != nil {
+			failed = true
+			if parent.Err() == nil {
+				slog.Warn("Could not store a listings snapshot", "store", store.name, "err", err)
+			}
+		}
+	}
+	if failed {
+		snapshotsTaken.inc("error")
+		return
+	}
+	snapshotsTaken.inc("ok")
+	slog.Debug("Stored a listings snapshot", "coins", len(resp.Data))
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"server/types"
+	"testing"
+	"time"
+)
+
+func TestSnapshotStorePruneForgetsCached(t *testing.T) {
+	s, err := openSnapshotStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for k := 0; k < 5; k++ {
+		if err := s.add(types.Snapshot{Time: start.Add(time.Duration(k) * time.Hour)}); err != nil {
+			t.Fatalf("add() error = %v", err)
+		}
+	}
+	s.prune(start.Add(3 * time.Hour))
+
+	if len(s.times) != 2 {
+		t.Errorf("len(times) = %d, want 2", len(s.times))
+	}
+	if len(s.order) != len(s.cache) {
+		t.Fatalf("len(order) = %d, len(cache) = %d, want them equal", len(s.order), len(s.cache))
+	}
+	for _, o := range s.order {
+		if _, ok := s.cache[o]; !ok {
+			t.Errorf("order lists %v, which is not cached", o)
+		}
+	}
+}
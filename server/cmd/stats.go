@@ -0,0 +1,391 @@
+package main
+
+import (
+	"math"
+	"server/types"
+	"sort"
+)
+
+// The statistics of the listings endpoint work on the USD prices of a
+// listing page; the ...Of helpers underneath work on any series and are
+// shared with the technical indicators.
+
+func CalculateAverage(listings types.Response) float64 {
+	return averageOf(listingPrices(listings))
+}
+
+func CalculateMedian(listings types.Response) float64 {
+	return medianOf(listingPrices(listings))
+}
+
+func CalculateStandardDeviation(listings types.Response) float64 {
+	return standardDeviationOf(listingPrices(listings))
+}
+
+func CalculateMax(listings types.Response) float64 {
+	var max float64
+	for _, listing := range listings.Data {
+		if listing.Quote["USD"].Price > max {
+			max = listing.Quote["USD"].Price
+		}
+	}
+	return max
+}
+
+func CalculateMin(listings types.Response) float64 {
+	min := listings.Data[0].Quote["USD"].Price
+	for _, listing := range listings.Data {
+		if listing.Quote["USD"].Price < min {
+			min = listing.Quote["USD"].Price
+		}
+	}
+	return min
+}
+
+func listingPrices(listings types.Response) []float64 {
+	prices := make([]float64, len(listings.Data))
+	for i, listing := range listings.Data {
+		prices[i] = listing.Quote["USD"].Price
+	}
+	return prices
+}
+
+func averageOf(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+func medianOf(values []float64) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+
+	middle := len(sorted) / 2
+	median := sorted[middle]
+	if len(sorted)%2 == 0 {
+		median = (median + sorted[middle-1]) / 2
+	}
+	return median
+}
+
+// standardDeviationOf is the population standard deviation of values.
+func standardDeviationOf(values []float64) float64 {
+	var sum float64
+	average := averageOf(values)
+	for _, v := range values {
+		deviation := v - average
+		sum += deviation * deviation
+	}
+	variance := sum / float64(len(values))
+	return math.Sqrt(variance)
+}
+
+// The indicators below return one value per input value. Values the window
+// does not cover yet are NaN, as is everything computed from a NaN.
+
+// CalculateSMA is the simple moving average over window values.
+func CalculateSMA(values []float64, window int) []float64 {
+	out := nanSeries(len(values))
+	for i := window - 1; i < len(values); i++ {
+		out[i] = averageOf(values[i-window+1 : i+1])
+	}
+	return out
+}
+
+// CalculateEMA is the exponential moving average with smoothing
+// 2/(window+1), seeded with the simple average of the first window values.
+// Leading NaNs are skipped, so it can smooth another indicator.
+func CalculateEMA(values []float64, window int) []float64 {
+	return smooth(values, window, 2/float64(window+1))
+}
+
+// CalculateRSI is Wilder's relative strength index over window changes.
+func CalculateRSI(values []float64, window int) []float64 {
+	gains := nanSeries(len(values))
+	losses := nanSeries(len(values))
+	for i := 1; i < len(values); i++ {
+		change := values[i] - values[i-1]
+		gains[i] = math.Max(change, 0)
+		losses[i] = math.Max(-change, 0)
+	}
+
+	avgGain := wilder(gains, window)
+	avgLoss := wilder(losses, window)
+	out := nanSeries(len(values))
+	for i := range values {
+		switch {
+		case math.IsNaN(avgGain[i]):
+		case avgLoss[i] == 0 && avgGain[i] == 0:
+			out[i] = 50
+		case avgLoss[i] == 0:
+			out[i] = 100
+		default:
+			out[i] = 100 - 100/(1+avgGain[i]/avgLoss[i])
+		}
+	}
+	return out
+}
+
+// CalculateMACD is the difference of the fast and slow EMAs, the signal
+// EMA of that difference and the histogram between the two.
+func CalculateMACD(values []float64, fast, slow, signal int) (macd, signalLine, histogram []float64) {
+	fastEMA := CalculateEMA(values, fast)
+	slowEMA := CalculateEMA(values, slow)
+
+	macd = make([]float64, len(values))
+	for i := range values {
+		macd[i] = fastEMA[i] - slowEMA[i]
+	}
+	signalLine = CalculateEMA(macd, signal)
+	histogram = make([]float64, len(values))
+	for i := range values {
+		histogram[i] = macd[i] - signalLine[i]
+	}
+	return macd, signalLine, histogram
+}
+
+// CalculateBollinger is the simple moving average over window values with
+// bands k population standard deviations above and below it.
+func CalculateBollinger(values []float64, window int, k float64) (upper, middle, lower []float64) {
+	upper, middle, lower = nanSeries(len(values)), CalculateSMA(values, window), nanSeries(len(values))
+	for i := window - 1; i < len(values); i++ {
+		deviation := standardDeviationOf(values[i-window+1 : i+1])
+		upper[i] = middle[i] + k*deviation
+		lower[i] = middle[i] - k*deviation
+	}
+	return upper, middle, lower
+}
+
+// CalculateATR is Wilder's average true range over window candles.
+func CalculateATR(high, low, close []float64, window int) []float64 {
+	trueRange := nanSeries(len(close))
+	for i := 1; i < len(close); i++ {
+		trueRange[i] = math.Max(high[i]-low[i], math.Max(math.Abs(high[i]-close[i-1]), math.Abs(low[i]-close[i-1])))
+	}
+	return wilder(trueRange, window)
+}
+
+// wilder is Wilder's smoothing, an EMA with smoothing 1/window.
+func wilder(values []float64, window int) []float64 {
+	return smooth(values, window, 1/float64(window))
+}
+
+func smooth(values []float64, window int, alpha float64) []float64 {
+	out := nanSeries(len(values))
+
+	start := 0
+	for start < len(values) && math.IsNaN(values[start]) {
+		start++
+	}
+	seed := start + window - 1
+	if seed >= len(values) {
+		return out
+	}
+
+	out[seed] = averageOf(values[start : seed+1])
+	for i := seed + 1; i < len(values); i++ {
+		out[i] = alpha*values[i] + (1-alpha)*out[i-1]
+	}
+	return out
+}
+
+func nanSeries(n int) []float64 {
+	out := make([]float64, n)
+	for i := range out {
+		out[i] = math.NaN()
+	}
+	return out
+}
+
+// The risk measures below work on the simple returns of a price series, as
+// returned by CalculateReturns, and annualize with the number of return
+// periods in a year.
+
+// CalculateReturns is the relative change between consecutive values, one
+// shorter than values.
+func CalculateReturns(values []float64) []float64 {
+	if len(values) < 2 {
+		return []float64{}
+	}
+	returns := make([]float64, len(values)-1)
+	for i := 1; i < len(values); i++ {
+		returns[i-1] = values[i]/values[i-1] - 1
+	}
+	return returns
+}
+
+// CalculateVolatility is the annualized standard deviation of returns.
+func CalculateVolatility(returns []float64, periodsPerYear float64) float64 {
+	return standardDeviationOf(returns) * math.Sqrt(periodsPerYear)
+}
+
+// CalculateRollingVolatility is CalculateVolatility over the window returns
+// ending at each return, NaN until window returns are known.
+func CalculateRollingVolatility(returns []float64, window int, periodsPerYear float64) []float64 {
+	out := nanSeries(len(returns))
+	for i := window - 1; i < len(returns); i++ {
+		out[i] = CalculateVolatility(returns[i-window+1:i+1], periodsPerYear)
+	}
+	return out
+}
+
+// CalculateMaxDrawdown is the largest fall of values from a previous peak,
+// as a non-positive fraction of the peak, with the indexes of that peak and
+// of the bottom. longest is the most values spent below a previous peak,
+// counted from the peak to the value that regained it or to the end.
+func CalculateMaxDrawdown(values []float64) (drawdown float64, peak, trough, longest int) {
+	high, since := 0, 0
+	for i, v := range values {
+		if v >= values[high] {
+			high, since = i, i
+			continue
+		}
+		if d := v/values[high] - 1; d < drawdown {
+			drawdown, peak, trough = d, high, i
+		}
+		longest = max(longest, i-since)
+	}
+	return drawdown, peak, trough, longest
+}
+
+// CalculateSharpe is the annualized mean excess return per unit of
+// volatility. riskFree is the return of one period.
+func CalculateSharpe(returns []float64, riskFree, periodsPerYear float64) float64 {
+	deviation := standardDeviationOf(returns)
+	if deviation == 0 {
+		return math.NaN()
+	}
+	return (averageOf(returns) - riskFree) / deviation * math.Sqrt(periodsPerYear)
+}
+
+// CalculateSortino is CalculateSharpe with only the returns below riskFree
+// counted as risk.
+func CalculateSortino(returns []float64, riskFree, periodsPerYear float64) float64 {
+	var sum float64
+	for _, r := range returns {
+		if r < riskFree {
+			sum += (r - riskFree) * (r - riskFree)
+		}
+	}
+	downside := math.Sqrt(sum / float64(len(returns)))
+	if downside == 0 {
+		return math.NaN()
+	}
+	return (averageOf(returns) - riskFree) / downside * math.Sqrt(periodsPerYear)
+}
+
+// CalculateBeta is the covariance of returns with the market returns of
+// the same periods over the variance of the market returns.
+func CalculateBeta(returns, market []float64) float64 {
+	averageReturn, averageMarket := averageOf(returns), averageOf(market)
+	var covariance, variance float64
+	for i := range returns {
+		covariance += (returns[i] - averageReturn) * (market[i] - averageMarket)
+		variance += (market[i] - averageMarket) * (market[i] - averageMarket)
+	}
+	if variance == 0 {
+		return math.NaN()
+	}
+	return covariance / variance
+}
+
+// CalculatePearson is the Pearson correlation of two series of the same
+// length, NaN when either is flat.
+func CalculatePearson(x, y []float64) float64 {
+	averageX, averageY := averageOf(x), averageOf(y)
+	var covariance, varianceX, varianceY float64
+	for i := range x {
+		dx, dy := x[i]-averageX, y[i]-averageY
+		covariance += dx * dy
+		varianceX += dx * dx
+		varianceY += dy * dy
+	}
+	if varianceX == 0 || varianceY == 0 {
+		return math.NaN()
+	}
+	return covariance / math.Sqrt(varianceX*varianceY)
+}
+
+// CalculateSpearman is the rank correlation of two series of the same
+// length, the Pearson correlation of their ranks.
+func CalculateSpearman(x, y []float64) float64 {
+	return CalculatePearson(ranksOf(x), ranksOf(y))
+}
+
+// ranksOf is the 1-based rank of every value; equal values share the
+// average of their ranks.
+func ranksOf(values []float64) []float64 {
+	order := make([]int, len(values))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return values[order[a]] < values[order[b]] })
+
+	ranks := make([]float64, len(values))
+	for i := 0; i < len(order); {
+		j := i
+		for j+1 < len(order) && values[order[j+1]] == values[order[i]] {
+			j++
+		}
+		rank := float64(i+j)/2 + 1
+		for k := i; k <= j; k++ {
+			ranks[order[k]] = rank
+		}
+		i = j + 1
+	}
+	return ranks
+}
+
+// The outlier fences below bound the values that are not outliers; a value
+// is an outlier when it lies strictly outside them. values must not be
+// empty.
+
+// CalculateZScoreFences is the mean plus and minus k standard deviations.
+func CalculateZScoreFences(values []float64, k float64) (lower, upper float64) {
+	average, deviation := averageOf(values), standardDeviationOf(values)
+	return average - k*deviation, average + k*deviation
+}
+
+// CalculateMADFences bounds a modified z-score of k, the median plus and
+// minus k median absolute deviations scaled to a standard deviation. When
+// more than half the values are equal the MAD is zero and the mean
+// absolute deviation, scaled alike, is used instead.
+func CalculateMADFences(values []float64, k float64) (lower, upper float64) {
+	median := medianOf(values)
+	deviations := make([]float64, len(values))
+	for i, v := range values {
+		deviations[i] = math.Abs(v - median)
+	}
+
+	scale := medianOf(deviations) / 0.6745
+	if scale == 0 {
+		scale = averageOf(deviations) * 1.2533
+	}
+	return median - k*scale, median + k*scale
+}
+
+// CalculateIQRFences is Tukey's fences, k interquartile ranges below the
+// first quartile and above the third.
+func CalculateIQRFences(values []float64, k float64) (lower, upper float64) {
+	q1, q3 := quartilesOf(values)
+	return q1 - k*(q3-q1), q3 + k*(q3-q1)
+}
+
+// quartilesOf is the first and third quartile of values, interpolated
+// between the closest ranks.
+func quartilesOf(values []float64) (q1, q3 float64) {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+
+	quantile := func(q float64) float64 {
+		pos := q * float64(len(sorted)-1)
+		i := int(pos)
+		if i+1 == len(sorted) {
+			return sorted[i]
+		}
+		return sorted[i] + (pos-float64(i))*(sorted[i+1]-sorted[i])
+	}
+	return quantile(0.25), quantile(0.75)
+}
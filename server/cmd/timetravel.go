@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"server/types"
+	"sort"
+	"time"
+)
+
+// serveSnapshotListings answers a listings request with at= from the stored
+// snapshot nearest to req.At. The snapshot is sorted, bounded, paged and
+// filtered in the order CoinMarketCap and handleApiRequest apply those
+// steps live, so the page and its stats come out as they were then.
+func serveSnapshotListings(w http.ResponseWriter, r *http.Request, req listingsRequest) {
+	t, ok := snapshots.nearest(req.At)
+	if !ok {
+		writeError(w, r, req.Format, errNotFound("No listing snapshot is stored yet."))
+		return
+	}
+	snap, err := snapshots.load(t)
+	if err != nil {
+		writeError(w, r, req.Format, errInternal(err))
+		return
+	}
+
+	rows := rankedListings(snap.Listings)
+	rows = filterListings(upstreamBounds(req.Query.Filter), rows)
+
+	page := []types.CryptoListing{}
+	if from := req.Query.Start - 1; from < len(rows) {
+		page = rows[from:min(from+req.Query.Limit, len(rows))]
+	}
+	fetched := len(page)
+	// a full snapshot stops at cfg.SnapshotLimit coins, so when the bounds
+	// leave the page short, coins ranked below it could have filled the rest
+	if fetched < req.Query.Limit && len(snap.Listings) >= cfg.SnapshotLimit {
+		writeError(w, r, req.Format, &validationError{Fields: []fieldError{{
+			Field:   "at",
+			Message: fmt.Sprintf("the snapshot keeps only the top %d coins by market cap, too few of them fall within the filter to fill this page", cfg.SnapshotLimit),
+		}}})
+		return
+	}
+
+	responseData := types.Response{Data: filterListings(req.Query.Filter, page), Status: snap.Status}
+
+	// the CoinGecko cross-check is a live call, snapshots do not keep it
+	priceOfCoinOtherApi := "Not kept in snapshots"
+
+	info := snapshotInfo(req.At, snap.Time)
+	writeListings(w, r, req, responseData, fetched, priceOfCoinOtherApi, &info)
+}
+
+// snapshotInfo describes the snapshot taken at taken that answered a query
+// for requested.
+func snapshotInfo(requested, taken time.Time) types.SnapshotInfo {
+	offset := taken.Sub(requested)
+	return types.SnapshotInfo{
+		RequestedAt:   requested,
+		SnapshotAt:    taken,
+		Offset:        offset.Round(time.Second).String(),
+		OffsetSeconds: offset.Seconds(),
+	}
+}
+
+// rankedListings returns a copy of listings in CoinMarketCap rank order,
+// the market cap order a snapshot query is limited to, see
+// checkSnapshotQuery.
+func rankedListings(listings []types.CryptoListing) []types.CryptoListing {
+	ranked := append([]types.CryptoListing{}, listings...)
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].CMCRank < ranked[j].CMCRank })
+	return ranked
+}
+
+// upstreamBounds is the part of f that addCMCParams sends to CoinMarketCap,
+// which applies it before paging.
+func upstreamBounds(f *types.ListingFilter) *types.ListingFilter {
+	if f == nil {
+		return nil
+	}
+	return &types.ListingFilter{
+		PriceMin:     f.PriceMin,
+		PriceMax:     f.PriceMax,
+		MarketCapMin: f.MarketCapMin,
+		MarketCapMax: f.MarketCapMax,
+		Volume24hMin: f.Volume24hMin,
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	templates "server/html"
+	"server/types"
+	"strings"
+	"sync"
+)
+
+// transactionStore keeps the imported transactions of every client in one
+// JSON file under cfg.DataDir, keyed by ownerID.
+type transactionStore struct {
+	mu   sync.Mutex
+	path string
+	txs  map[string][]types.Transaction
+}
+
+var transactions *transactionStore
+
+func openTransactionStore(dir string) (*transactionStore, error) {
+	s := &transactionStore{
+		path: filepath.Join(dir, "transactions.json"),
+		txs:  make(map[string][]types.Transaction),
+	}
+	if err := readJSONFile(s.path, &s.txs); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// flush writes transactions.json on shutdown.
+func (s *transactionStore) flush(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return writeJSONFile(s.path, s.txs)
+}
+
+func (s *transactionStore) list(apiKey string) []types.Transaction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]types.Transaction{}, s.txs[ownerID(apiKey)]...)
+}
+
+// addNew stores the transactions that are not stored yet, so importing the
+// same export twice does not double it. Identical rows within one file are
+// all kept, partial fills often look exactly alike.
+func (s *transactionStore) addNew(apiKey string, txs []types.Transaction) (added, duplicates int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	owner := ownerID(apiKey)
+	prev := s.txs[owner]
+
+	seen := make(map[string]bool, len(prev))
+	for _, tx := range prev {
+		seen[txFingerprint(tx)] = true
+	}
+
+	next := prev[:len(prev):len(prev)]
+	for _, tx := range txs {
+		if seen[txFingerprint(tx)] {
+			duplicates++
+			continue
+		}
+		next = append(next, tx)
+		added++
+	}
+	if added == 0 {
+		return 0, duplicates, nil
+	}
+
+	s.txs[owner] = next
+	if err := writeJSONFile(s.path, s.txs); err != nil {
+		s.txs[owner] = prev
+		return 0, 0, err
+	}
+	return added, duplicates, nil
+}
+
+func (s *transactionStore) clear(apiKey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	owner := ownerID(apiKey)
+	prev, ok := s.txs[owner]
+	if !ok {
+		return nil
+	}
+
+	delete(s.txs, owner)
+	if err := writeJSONFile(s.path, s.txs); err != nil {
+		s.txs[owner] = prev
+		return err
+	}
+	return nil
+}
+
+func txFingerprint(tx types.Transaction) string {
+	return fmt.Sprintf("%d|%s|%s|%g|%g|%g", tx.Time.UnixNano(), tx.Type, tx.Symbol, tx.Quantity, tx.Price, tx.Fee)
+}
+
+// transactionsHandler serves /api/portfolio/transactions. GET lists the
+// stored transactions, POST imports a CSV export and DELETE removes every
+// transaction of the caller.
+//
+// The CSV may be uploaded as the file field of a multipart form, sent as
+// the body with Content-Type text/csv, or passed as the csv field of a form
+// or JSON body.
+func transactionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		writeError(w, r, preferredFormat(r), errMethodNotAllowed(http.MethodGet, http.MethodPost, http.MethodDelete))
+		return
+	}
+
+	// the multipart form has to be parsed before the api-key in it can be
+	// checked
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if ct == "multipart/form-data" {
+		r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+		if err := r.ParseMultipartForm(maxImportBytes); err != nil {
+			writeError(w, r, preferredFormat(r), &validationError{Fields: []fieldError{{Field: "body", Message: "malformed or too large multipart form"}}})
+			return
+		}
+	}
+
+	p, format, err := clientParams(r)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+	method := p.enum("method", "fifo", costMethods, false)
+	apiKey := p.str("api-key")
+
+	if r.Method == http.MethodPost {
+		importTransactions(w, r, p, format, method, ct)
+		return
+	}
+	if err := p.err(); err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	if !allowRequest(w, r, format) {
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if err := transactions.clear(apiKey); err != nil {
+			writeError(w, r, format, errInternal(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if format == "json" {
+		writeJSON(w, http.StatusOK, transactions.list(apiKey))
+		return
+	}
+	report, err := currentPnL(r, apiKey, method)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+	renderHTML(w, r, http.StatusOK, templates.PnL, report)
+}
+
+func importTransactions(w http.ResponseWriter, r *http.Request, p *params, format, method, contentType string) {
+	layout := p.enum("layout", "auto", layoutNames(), false)
+
+	var data io.Reader
+	switch {
+	case contentType == "text/csv":
+		data = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	case contentType == "multipart/form-data" && r.MultipartForm.File["file"] != nil:
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			p.fail("file", "could not be read")
+			break
+		}
+		defer f.Close()
+		data = f
+	case p.values.Get("csv") != "":
+		data = strings.NewReader(p.values.Get("csv"))
+	default:
+		p.fail("csv", "is required, as a file upload, a text/csv body or a csv field")
+	}
+	if err := p.err(); err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	if !allowRequest(w, r, format) {
+		return
+	}
+
+	listings, err := latestListings.get(r.Context(), upstreamClient)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	txs, result, err := importCSV(data, layout, listings)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+	for i := range txs {
+		if txs[i].ID, err = newID(); err != nil {
+			writeError(w, r, format, errInternal(err))
+			return
+		}
+	}
+
+	apiKey := p.str("api-key")
+	result.Imported, result.Duplicates, err = transactions.addNew(apiKey, txs)
+	if err != nil {
+		writeError(w, r, format, errInternal(err))
+		return
+	}
+
+	if format == "json" {
+		writeJSON(w, http.StatusCreated, result)
+		return
+	}
+
+	report := computePnL(transactions.list(apiKey), method, listingsByID(listings))
+	report.Import = &result
+	report.APIKey = apiKey
+	renderHTML(w, r, http.StatusCreated, templates.PnL, report)
+}
+
+// pnlHandler serves /api/portfolio/pnl, realized and unrealized P&L of the
+// imported transactions with the lot matching method given by method.
+func pnlHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeError(w, r, preferredFormat(r), errMethodNotAllowed(http.MethodGet, http.MethodPost))
+		return
+	}
+
+	p, format, err := clientParams(r)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+	method := p.enum("method", "fifo", costMethods, false)
+	if err := p.err(); err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	if !allowRequest(w, r, format) {
+		return
+	}
+
+	report, err := currentPnL(r, p.str("api-key"), method)
+	if err != nil {
+		writeError(w, r, format, err)
+		return
+	}
+
+	if format == "json" {
+		writeJSON(w, http.StatusOK, report)
+		return
+	}
+	renderHTML(w, r, http.StatusOK, templates.PnL, report)
+}
+
+// currentPnL computes the report of apiKey at the cached quotes. Without
+// transactions the listings are not needed.
+func currentPnL(r *http.Request, apiKey, method string) (types.PnLReport, error) {
+	txs := transactions.list(apiKey)
+
+	var byID map[int]types.CryptoListing
+	if len(txs) > 0 {
+		listings, err := latestListings.get(r.Context(), upstreamClient)
+		if err != nil {
+			return types.PnLReport{}, err
+		}
+		byID = listingsByID(listings)
+	}
+
+	report := computePnL(txs, method, byID)
+	report.APIKey = apiKey
+	return report, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// upstreamClient is shared by every call to the data providers.
+var upstreamClient = &http.Client{Transport: upstreamTransport{next: http.DefaultTransport}}
+
+// upstreamTransport logs and measures every call made to the data
+// providers. Log lines carry the request ID of the incoming request that
+// triggered the call.
+type upstreamTransport struct {
+	next http.RoundTripper
+}
+
+func (t upstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	provider := providerName(req.URL.Host)
+
+	resp, err := t.next.RoundTrip(req)
+	upstreamDuration.observe(time.Since(start).Seconds(), provider)
+	if err != nil {
+		upstreamRequests.inc(provider, "error")
+		upstreamErrors.inc(provider)
+		slog.WarnContext(req.Context(), "upstream call failed",
+			"provider", provider,
+			"path", req.URL.Path,
+			"duration", time.Since(start),
+			"err", err,
+		)
+		return nil, err
+	}
+
+	upstreamRequests.inc(provider, strconv.Itoa(resp.StatusCode))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		upstreamErrors.inc(provider)
+	}
+
+	slog.DebugContext(req.Context(), "upstream call",
+		"provider", provider,
+		"method", req.Method,
+		"path", req.URL.Path,
+		"status", resp.StatusCode,
+		"duration", time.Since(start),
+	)
+	return resp, nil
+}
+
+func providerName(host string) string {
+	switch {
+	case strings.HasSuffix(host, "coinmarketcap.com"):
+		return "coinmarketcap"
+	case strings.HasSuffix(host, "coingecko.com"):
+		return "coingecko"
+	}
+	return host
+}
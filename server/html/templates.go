@@ -7,3 +7,57 @@ var Results string
 
 //go:embed index.html
 var Index string
+
+//go:embed docs.html
+var Docs string
+
+//go:embed error.html
+var Error string
+
+//go:embed coin.html
+var Coin string
+
+//go:embed portfolio.html
+var Portfolio string
+
+//go:embed holding.html
+var Holding string
+
+//go:embed pnl.html
+var PnL string
+
+//go:embed compare.html
+var Compare string
+
+//go:embed search.html
+var Search string
+
+//go:embed diff.html
+var Diff string
+
+//go:embed candles.html
+var Candles string
+
+//go:embed indicators.html
+var Indicators string
+
+//go:embed risk.html
+var Risk string
+
+//go:embed correlation.html
+var Correlation string
+
+//go:embed indices.html
+var Indices string
+
+//go:embed basket.html
+var Basket string
+
+//go:embed market.html
+var Market string
+
+//go:embed stablecoins.html
+var Stablecoins string
+
+//go:embed peg.html
+var Peg string
@@ -1,13 +1,31 @@
 package types
 
+import "time"
+
 type ResponseToHttp struct {
-	Response            Response `json:"response"`
-	Average             float64  `json:"average"`
-	Median              float64  `json:"median"`
-	StandardDeviation   float64  `json:"standard_deviation"`
-	Max                 float64  `json:"max"`
-	Min                 float64  `json:"min"`
-	PriceOfCoinOtherApi string   `json:"price_of_coin_other_api"`
+	Response            Response       `json:"response"`
+	Average             float64        `json:"average"`
+	Median              float64        `json:"median"`
+	StandardDeviation   float64        `json:"standard_deviation"`
+	Max                 float64        `json:"max"`
+	Min                 float64        `json:"min"`
+	PriceOfCoinOtherApi string         `json:"price_of_coin_other_api"`
+	Filter              *ListingFilter `json:"filter,omitempty"`
+	Pagination          Pagination     `json:"pagination"`
+	// Snapshot is set when the listing was rebuilt from a stored snapshot
+	// instead of being fetched live
+	Snapshot *SnapshotInfo `json:"snapshot,omitempty"`
+	// Risk is set when risk metrics of the listed coins were asked for
+	Risk *ListingRisk `json:"risk,omitempty"`
+	// Outliers is set when outlier detection was asked for
+	Outliers *OutlierReport `json:"outliers,omitempty"`
+	Caller
+}
+
+// Caller is embedded in the types the HTML pages render, so a page can link
+// and post back with the caller's api-key. The key is never serialized.
+type Caller struct {
+	APIKey string `json:"-"`
 }
 
 type Response struct {
@@ -70,3 +88,662 @@ type Coin struct {
 	Name   string `json:"name"`
 	Symbol string `json:"symbol"`
 }
+
+type ListingFilter struct {
+	Tags         []string `json:"tags,omitempty"`
+	ExcludeTags  []string `json:"exclude_tags,omitempty"`
+	Platforms    []string `json:"platforms,omitempty"`
+	PriceMin     *float64 `json:"price_min,omitempty"`
+	PriceMax     *float64 `json:"price_max,omitempty"`
+	MarketCapMin *float64 `json:"market_cap_min,omitempty"`
+	MarketCapMax *float64 `json:"market_cap_max,omitempty"`
+	Volume24hMin *float64 `json:"volume_24h_min,omitempty"`
+}
+
+type Pagination struct {
+	Start   int    `json:"start"`
+	Limit   int    `json:"limit"`
+	SortDir string `json:"sort_dir"`
+	Next    string `json:"next,omitempty"`
+	Prev    string `json:"prev,omitempty"`
+}
+
+type CoinDetail struct {
+	Coin                CryptoListing `json:"coin"`
+	Currencies          []string      `json:"currencies"`
+	CoinGeckoID         string        `json:"coingecko_id,omitempty"`
+	PriceOfCoinOtherApi string        `json:"price_of_coin_other_api"`
+	// History is the recent USD price series built from the stored
+	// snapshots, absent when none of them has the coin
+	History []Candle `json:"history,omitempty"`
+	Caller
+}
+
+// Holding is one position in a portfolio. CostBasis is the total USD paid
+// for Quantity, not the price per coin.
+type Holding struct {
+	ID        string  `json:"id"`
+	CoinID    int     `json:"coin_id"`
+	Name      string  `json:"name"`
+	Symbol    string  `json:"symbol"`
+	Slug      string  `json:"slug"`
+	Quantity  float64 `json:"quantity"`
+	CostBasis float64 `json:"cost_basis"`
+	Acquired  string  `json:"acquired"`
+}
+
+// HoldingValue is a holding valued at the latest USD quote. Priced is false
+// when the coin is not in the cached listings, all values are zero then.
+type HoldingValue struct {
+	Holding
+	Priced               bool    `json:"priced"`
+	Price                float64 `json:"price"`
+	Value                float64 `json:"value"`
+	Allocation           float64 `json:"allocation"`
+	Change24h            float64 `json:"change_24h"`
+	PercentChange24h     float64 `json:"percent_change_24h"`
+	Change7d             float64 `json:"change_7d"`
+	PercentChange7d      float64 `json:"percent_change_7d"`
+	UnrealizedPnL        float64 `json:"unrealized_pnl"`
+	UnrealizedPnLPercent float64 `json:"unrealized_pnl_percent"`
+	Caller
+}
+
+// PortfolioValue sums the priced holdings; unpriced ones are listed but
+// left out of every total.
+type PortfolioValue struct {
+	Holdings             []HoldingValue `json:"holdings"`
+	TotalValue           float64        `json:"total_value"`
+	TotalCost            float64        `json:"total_cost"`
+	Change24h            float64        `json:"change_24h"`
+	PercentChange24h     float64        `json:"percent_change_24h"`
+	Change7d             float64        `json:"change_7d"`
+	PercentChange7d      float64        `json:"percent_change_7d"`
+	UnrealizedPnL        float64        `json:"unrealized_pnl"`
+	UnrealizedPnLPercent float64        `json:"unrealized_pnl_percent"`
+	Unpriced             int            `json:"unpriced"`
+	Caller
+}
+
+// Transaction is one imported ledger entry. Type is buy, sell, transfer_in
+// or transfer_out; Price is the USD price per coin and Fee the total fee in
+// USD.
+type Transaction struct {
+	ID       string    `json:"id"`
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`
+	Symbol   string    `json:"symbol"`
+	CoinID   int       `json:"coin_id,omitempty"`
+	Name     string    `json:"name,omitempty"`
+	Quantity float64   `json:"quantity"`
+	Price    float64   `json:"price"`
+	Fee      float64   `json:"fee"`
+	// Source is the CSV layout the transaction was imported from
+	Source string `json:"source"`
+}
+
+// ImportResult summarises one CSV import. Rows that describe something we
+// do not track, such as a conversion between two coins, are skipped with a
+// reason instead of failing the whole file.
+type ImportResult struct {
+	Layout     string       `json:"layout"`
+	Imported   int          `json:"imported"`
+	Duplicates int          `json:"duplicates"`
+	Skipped    []SkippedRow `json:"skipped"`
+}
+
+type SkippedRow struct {
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+}
+
+// PnLReport is the profit and loss of the imported transactions with lots
+// matched by Method (fifo, lifo or average).
+type PnLReport struct {
+	Method        string        `json:"method"`
+	Coins         []CoinPnL     `json:"coins"`
+	Years         []YearGains   `json:"years"`
+	Value         float64       `json:"value"`
+	CostBasis     float64       `json:"cost_basis"`
+	RealizedPnL   float64       `json:"realized_pnl"`
+	UnrealizedPnL float64       `json:"unrealized_pnl"`
+	Fees          float64       `json:"fees"`
+	Transactions  []Transaction `json:"transactions"`
+	Import        *ImportResult `json:"import,omitempty"`
+	Caller
+}
+
+// CoinPnL is the open position and the gains of one coin. Unmatched is the
+// quantity sold or sent out that no earlier buy covered; it is treated as
+// having zero cost basis.
+type CoinPnL struct {
+	Symbol        string  `json:"symbol"`
+	Name          string  `json:"name,omitempty"`
+	CoinID        int     `json:"coin_id,omitempty"`
+	Quantity      float64 `json:"quantity"`
+	CostBasis     float64 `json:"cost_basis"`
+	Priced        bool    `json:"priced"`
+	Price         float64 `json:"price"`
+	Value         float64 `json:"value"`
+	RealizedPnL   float64 `json:"realized_pnl"`
+	UnrealizedPnL float64 `json:"unrealized_pnl"`
+	Fees          float64 `json:"fees"`
+	Unmatched     float64 `json:"unmatched"`
+}
+
+// YearGains are the realized gains of one calendar year (UTC).
+type YearGains struct {
+	Year        int     `json:"year"`
+	Sells       int     `json:"sells"`
+	Proceeds    float64 `json:"proceeds"`
+	CostBasis   float64 `json:"cost_basis"`
+	Fees        float64 `json:"fees"`
+	RealizedPnL float64 `json:"realized_pnl"`
+}
+
+// Comparison lines up the same metrics for several coins. Every metric has
+// one value per coin, in the order of Coins.
+type Comparison struct {
+	Coins   []ComparedCoin `json:"coins"`
+	Metrics []Metric       `json:"metrics"`
+	Ratios  []Ratio        `json:"ratios"`
+	Caller
+}
+
+type ComparedCoin struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Symbol string `json:"symbol"`
+	Slug   string `json:"slug"`
+}
+
+type Metric struct {
+	Name   string        `json:"name"`
+	Values []MetricValue `json:"values"`
+}
+
+// MetricValue is nil with rank 0 when the coin has no value for the
+// metric, such as a max supply for a coin without a cap. Rank 1 is the
+// highest value within the selection.
+type MetricValue struct {
+	Value *float64 `json:"value"`
+	Rank  int      `json:"rank"`
+}
+
+// Ratio divides the metrics of Base by those of Quote, so an ETH/BTC price
+// ratio is the price of ETH in BTC.
+type Ratio struct {
+	Base      string   `json:"base"`
+	Quote     string   `json:"quote"`
+	Price     *float64 `json:"price"`
+	MarketCap *float64 `json:"market_cap"`
+	Volume24h *float64 `json:"volume_24h"`
+}
+
+// CMCMapEntry is one coin of the CoinMarketCap ID map. Rank is the market
+// cap rank, zero for coins without one.
+type CMCMapEntry struct {
+	ID     int    `json:"id"`
+	Rank   int    `json:"rank"`
+	Name   string `json:"name"`
+	Symbol string `json:"symbol"`
+	Slug   string `json:"slug"`
+}
+
+// SearchResult lists the coins matching Query, best match first.
+type SearchResult struct {
+	Query   string        `json:"query"`
+	Results []SearchMatch `json:"results"`
+	Caller
+}
+
+// SearchMatch is a coin known to CoinMarketCap, CoinGecko or both. Match is
+// exact, prefix, substring or fuzzy and MatchedOn the field that matched;
+// Distance is the edit distance of a fuzzy match.
+type SearchMatch struct {
+	Name        string `json:"name"`
+	Symbol      string `json:"symbol"`
+	Slug        string `json:"slug,omitempty"`
+	CMCID       int    `json:"cmc_id,omitempty"`
+	CoinGeckoID string `json:"coingecko_id,omitempty"`
+	Rank        int    `json:"rank,omitempty"`
+	Match       string `json:"match"`
+	MatchedOn   string `json:"matched_on"`
+	Distance    int    `json:"distance"`
+}
+
+// Suggestions is the autocomplete answer: Value is what to put in a coin
+// field, Label what to show.
+type Suggestions struct {
+	Query       string       `json:"query"`
+	Suggestions []Suggestion `json:"suggestions"`
+}
+
+type Suggestion struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+// Snapshot is the market listing as it was at Time, the top coins by market
+// cap.
+type Snapshot struct {
+	Time     time.Time       `json:"time"`
+	Status   Status          `json:"status"`
+	Listings []CryptoListing `json:"listings"`
+}
+
+// SnapshotInfo says which snapshot answered a time-travel query. Offset is
+// the snapshot time minus the requested time, negative when the snapshot is
+// older.
+type SnapshotInfo struct {
+	RequestedAt   time.Time `json:"requested_at"`
+	SnapshotAt    time.Time `json:"snapshot_at"`
+	Offset        string    `json:"offset"`
+	OffsetSeconds float64   `json:"offset_seconds"`
+}
+
+// SnapshotDiff compares the top Top coins of two snapshots. Coins holds
+// every coin in the top of either snapshot, by its rank in To; the mover
+// lists are the largest changes among the coins in both.
+type SnapshotDiff struct {
+	From             SnapshotInfo `json:"from"`
+	To               SnapshotInfo `json:"to"`
+	Top              int          `json:"top"`
+	Entered          []CoinDiff   `json:"entered"`
+	Left             []CoinDiff   `json:"left"`
+	RankGainers      []CoinDiff   `json:"rank_gainers"`
+	RankLosers       []CoinDiff   `json:"rank_losers"`
+	PriceGainers     []CoinDiff   `json:"price_gainers"`
+	PriceLosers      []CoinDiff   `json:"price_losers"`
+	MarketCapGainers []CoinDiff   `json:"market_cap_gainers"`
+	MarketCapLosers  []CoinDiff   `json:"market_cap_losers"`
+	TagChanges       []CoinDiff   `json:"tag_changes"`
+	Coins            []CoinDiff   `json:"coins"`
+	Caller
+}
+
+// CoinDiff is one coin in two snapshots. A rank of 0 means the coin is not
+// in that snapshot, the changes are zero then. RankChange is positive when
+// the coin moved up.
+type CoinDiff struct {
+	ID                     int      `json:"id"`
+	Name                   string   `json:"name"`
+	Symbol                 string   `json:"symbol"`
+	Slug                   string   `json:"slug"`
+	FromRank               int      `json:"from_rank"`
+	ToRank                 int      `json:"to_rank"`
+	RankChange             int      `json:"rank_change"`
+	FromPrice              float64  `json:"from_price"`
+	ToPrice                float64  `json:"to_price"`
+	PriceChange            float64  `json:"price_change"`
+	PriceChangePercent     float64  `json:"price_change_percent"`
+	FromMarketCap          float64  `json:"from_market_cap"`
+	ToMarketCap            float64  `json:"to_market_cap"`
+	MarketCapChange        float64  `json:"market_cap_change"`
+	MarketCapChangePercent float64  `json:"market_cap_change_percent"`
+	TagsAdded              []string `json:"tags_added,omitempty"`
+	TagsRemoved            []string `json:"tags_removed,omitempty"`
+}
+
+// Candle is the OHLCV summary of one interval starting at Time. Volume is
+// estimated from the changes in the rolling 24h volume of the samples in
+// it. Samples is the number of snapshots the candle was built from; a
+// filled candle has none and repeats the previous close.
+type Candle struct {
+	Time    time.Time `json:"time"`
+	Open    float64   `json:"open"`
+	High    float64   `json:"high"`
+	Low     float64   `json:"low"`
+	Close   float64   `json:"close"`
+	Volume  float64   `json:"volume"`
+	Samples int       `json:"samples"`
+	Filled  bool      `json:"filled,omitempty"`
+}
+
+// CandleSeries is the candles of one coin in one currency between From and
+// To. Gaps are the pauses in the snapshots, runs of missing candles longer
+// than the usual spacing of snapshots.
+type CandleSeries struct {
+	ID       int         `json:"id"`
+	Name     string      `json:"name"`
+	Symbol   string      `json:"symbol"`
+	Slug     string      `json:"slug"`
+	Currency string      `json:"currency"`
+	Interval string      `json:"interval"`
+	From     time.Time   `json:"from"`
+	To       time.Time   `json:"to"`
+	Candles  []Candle    `json:"candles"`
+	Gaps     []CandleGap `json:"gaps"`
+}
+
+// CandleGap is a run of missing candles, From is the first missing one and
+// To the end of the last.
+type CandleGap struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// IndicatorSeries is technical indicators computed from the candles of one
+// coin between From and To. Windows holds the parameters of the requested
+// indicators; the others are zero and left out.
+type IndicatorSeries struct {
+	ID       int              `json:"id"`
+	Name     string           `json:"name"`
+	Symbol   string           `json:"symbol"`
+	Slug     string           `json:"slug"`
+	Currency string           `json:"currency"`
+	Interval string           `json:"interval"`
+	From     time.Time        `json:"from"`
+	To       time.Time        `json:"to"`
+	Windows  IndicatorWindows `json:"windows"`
+	Points   []IndicatorPoint `json:"points"`
+}
+
+type IndicatorWindows struct {
+	SMA        int     `json:"sma,omitempty"`
+	EMA        int     `json:"ema,omitempty"`
+	RSI        int     `json:"rsi,omitempty"`
+	MACDFast   int     `json:"macd_fast,omitempty"`
+	MACDSlow   int     `json:"macd_slow,omitempty"`
+	MACDSignal int     `json:"macd_signal,omitempty"`
+	Bollinger  int     `json:"bollinger,omitempty"`
+	BollingerK float64 `json:"bollinger_k,omitempty"`
+	ATR        int     `json:"atr,omitempty"`
+}
+
+// IndicatorPoint is the indicators at the close of the candle starting at
+// Time. An indicator is absent until enough candles precede it.
+type IndicatorPoint struct {
+	Time           time.Time `json:"time"`
+	Close          float64   `json:"close"`
+	Filled         bool      `json:"filled,omitempty"`
+	SMA            *float64  `json:"sma,omitempty"`
+	EMA            *float64  `json:"ema,omitempty"`
+	RSI            *float64  `json:"rsi,omitempty"`
+	MACD           *float64  `json:"macd,omitempty"`
+	MACDSignal     *float64  `json:"macd_signal,omitempty"`
+	MACDHistogram  *float64  `json:"macd_histogram,omitempty"`
+	BollingerUpper *float64  `json:"bollinger_upper,omitempty"`
+	BollingerMid   *float64  `json:"bollinger_middle,omitempty"`
+	BollingerLower *float64  `json:"bollinger_lower,omitempty"`
+	ATR            *float64  `json:"atr,omitempty"`
+}
+
+// RiskMetrics describe the price history of one coin between two times.
+// Volatility is annualized and, like MaxDrawdown, in percent; MaxDrawdown
+// is the largest fall from a previous peak and is zero or negative.
+// DrawdownDuration is the longest time spent below a previous peak. Ratios
+// that are undefined for the history, such as Sharpe for a flat price, are
+// left out.
+type RiskMetrics struct {
+	Returns                 int        `json:"returns"`
+	Volatility              *float64   `json:"volatility,omitempty"`
+	MaxDrawdown             *float64   `json:"max_drawdown,omitempty"`
+	MaxDrawdownPeak         *time.Time `json:"max_drawdown_peak,omitempty"`
+	MaxDrawdownTrough       *time.Time `json:"max_drawdown_trough,omitempty"`
+	DrawdownDuration        string     `json:"drawdown_duration,omitempty"`
+	DrawdownDurationSeconds int64      `json:"drawdown_duration_seconds"`
+	Sharpe                  *float64   `json:"sharpe,omitempty"`
+	Sortino                 *float64   `json:"sortino,omitempty"`
+	Beta                    *float64   `json:"beta,omitempty"`
+}
+
+// RiskReport is the risk metrics of one coin from the Interval candles
+// between From and To, with the rolling volatility over Window returns.
+type RiskReport struct {
+	ID                int         `json:"id"`
+	Name              string      `json:"name"`
+	Symbol            string      `json:"symbol"`
+	Slug              string      `json:"slug"`
+	Currency          string      `json:"currency"`
+	Interval          string      `json:"interval"`
+	From              time.Time   `json:"from"`
+	To                time.Time   `json:"to"`
+	Window            int         `json:"window"`
+	RiskFreeRate      float64     `json:"risk_free_rate"`
+	Benchmark         string      `json:"benchmark"`
+	Metrics           RiskMetrics `json:"metrics"`
+	RollingVolatility []RiskPoint `json:"rolling_volatility"`
+}
+
+// ListingRisk is the risk metrics of the coins of a listing page by
+// CoinMarketCap ID, from the USD Interval candles between From and To.
+// Coins without stored candles are left out.
+type ListingRisk struct {
+	Interval     string               `json:"interval"`
+	From         time.Time            `json:"from"`
+	To           time.Time            `json:"to"`
+	RiskFreeRate float64              `json:"risk_free_rate"`
+	Benchmark    string               `json:"benchmark"`
+	Coins        map[int]*RiskMetrics `json:"coins"`
+}
+
+// OutlierReport flags the listings whose Metric lies outside the fences
+// of Method with Threshold, by CoinMarketCap ID. Policy exclude leaves the
+// outliers out of the stats, flag only reports them. Checked counts the
+// listings with a value for Metric; the fences are absent when none has.
+type OutlierReport struct {
+	Method    string           `json:"method"`
+	Metric    string           `json:"metric"`
+	Threshold float64          `json:"threshold"`
+	Policy    string           `json:"policy"`
+	Lower     *float64         `json:"lower_fence,omitempty"`
+	Upper     *float64         `json:"upper_fence,omitempty"`
+	Checked   int              `json:"checked"`
+	Excluded  int              `json:"excluded"`
+	Coins     map[int]*Outlier `json:"coins"`
+}
+
+// Outlier is a flagged listing, Side is above or below the fences.
+type Outlier struct {
+	Value float64 `json:"value"`
+	Side  string  `json:"side"`
+}
+
+type RiskPoint struct {
+	Time       time.Time `json:"time"`
+	Close      float64   `json:"close"`
+	Volatility *float64  `json:"volatility,omitempty"`
+}
+
+// CorrelationMatrix is the correlation of the candle returns of Coins
+// between From and To. Row i, column j of Pearson, Spearman and
+// Observations compare Coins[i] with Coins[j] over the Observations
+// returns both coins have; a correlation is left null when there are too
+// few of them or either coin did not move.
+type CorrelationMatrix struct {
+	Currency     string            `json:"currency"`
+	Interval     string            `json:"interval"`
+	From         time.Time         `json:"from"`
+	To           time.Time         `json:"to"`
+	Window       int               `json:"window"`
+	Coins        []CorrelationCoin `json:"coins"`
+	Pearson      [][]*float64      `json:"pearson"`
+	Spearman     [][]*float64      `json:"spearman"`
+	Observations [][]int           `json:"observations"`
+	Caller
+}
+
+// CorrelationCoin is one coin of a correlation matrix. Missing counts the
+// returns left out because the snapshots paused between two candles.
+type CorrelationCoin struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Symbol  string `json:"symbol"`
+	Slug    string `json:"slug"`
+	Returns int    `json:"returns"`
+	Missing int    `json:"missing"`
+}
+
+// IndexDefinition says how a custom index is composed. Method top holds
+// the Top coins by market cap without ExcludeTags, weighted by market cap or
+// equally; method fixed holds Weights. No constituent weighs more than Cap
+// percent when Cap is set. Rebalance is how often the weights are restored
+// and, for top, the constituents selected again.
+type IndexDefinition struct {
+	ID          string        `json:"id"`
+	Name        string        `json:"name"`
+	Method      string        `json:"method"`
+	Top         int           `json:"top,omitempty"`
+	Weighting   string        `json:"weighting,omitempty"`
+	ExcludeTags []string      `json:"exclude_tags,omitempty"`
+	Weights     []IndexWeight `json:"weights,omitempty"`
+	Cap         float64       `json:"cap,omitempty"`
+	Rebalance   string        `json:"rebalance"`
+	BaseValue   float64       `json:"base_value"`
+	Created     time.Time     `json:"created"`
+}
+
+// IndexWeight is the target weight of one coin of a fixed index, in
+// percent.
+type IndexWeight struct {
+	CoinID int     `json:"coin_id"`
+	Name   string  `json:"name"`
+	Symbol string  `json:"symbol"`
+	Weight float64 `json:"weight"`
+}
+
+// IndexConstituent is one coin held by an index. Units are fixed between
+// rebalances; Weight is the share of the NAV now, TargetWeight the share
+// set at the last rebalance, both in percent. Stale is set when the coin
+// was missing from the newest snapshot and is valued at its last price.
+type IndexConstituent struct {
+	CoinID       int     `json:"coin_id"`
+	Name         string  `json:"name"`
+	Symbol       string  `json:"symbol"`
+	Slug         string  `json:"slug"`
+	Units        float64 `json:"units"`
+	Price        float64 `json:"price"`
+	Value        float64 `json:"value"`
+	Weight       float64 `json:"weight"`
+	TargetWeight float64 `json:"target_weight"`
+	Stale        bool    `json:"stale,omitempty"`
+}
+
+// IndexPoint is the net asset value of an index at one snapshot.
+type IndexPoint struct {
+	Time time.Time `json:"time"`
+	NAV  float64   `json:"nav"`
+}
+
+// Index is a custom index with its USD net asset value as of Updated and
+// its constituents, largest first. History is only set when a single
+// index is shown.
+type Index struct {
+	IndexDefinition
+	NAV           float64            `json:"nav"`
+	ChangePercent float64            `json:"change_percent"`
+	Updated       time.Time          `json:"updated"`
+	LastRebalance time.Time          `json:"last_rebalance"`
+	NextRebalance *time.Time         `json:"next_rebalance,omitempty"`
+	Constituents  []IndexConstituent `json:"constituents"`
+	History       []IndexPoint       `json:"history,omitempty"`
+	Caller
+}
+
+// IndexList is the caller's indices without their history.
+type IndexList struct {
+	Indices []Index `json:"indices"`
+	Caller
+}
+
+// MarketOverview summarizes the top listings by market cap: the biggest
+// movers and the breadth of every percent change window. AboveWeekAgo is
+// the percentage of coins priced above their level of 7 days ago and
+// BTCDominance the share of Bitcoin in the total market cap, in percent;
+// it is absent when Bitcoin is not among the listings.
+type MarketOverview struct {
+	Coins        int            `json:"coins"`
+	Top          int            `json:"top"`
+	MinVolume    float64        `json:"volume_24h_min,omitempty"`
+	Windows      []MarketWindow `json:"windows"`
+	AboveWeekAgo float64        `json:"above_7d_percent"`
+	BTCDominance *float64       `json:"btc_dominance,omitempty"`
+	Caller
+}
+
+// MarketWindow is one percent change window of a market overview. Gainers
+// rose the most and Losers fell the most, at most Top of each; breadth
+// counts every coin, including the ones too thinly traded to be a mover.
+// AdvanceDecline is Advancers over Decliners, absent without decliners.
+type MarketWindow struct {
+	Window         string        `json:"window"`
+	Advancers      int           `json:"advancers"`
+	Decliners      int           `json:"decliners"`
+	Unchanged      int           `json:"unchanged"`
+	AdvanceDecline *float64      `json:"advance_decline_ratio,omitempty"`
+	Gainers        []MarketMover `json:"gainers"`
+	Losers         []MarketMover `json:"losers"`
+}
+
+// MarketMover is a coin with its percent change over one window.
+type MarketMover struct {
+	ID            int     `json:"id"`
+	Name          string  `json:"name"`
+	Symbol        string  `json:"symbol"`
+	Slug          string  `json:"slug"`
+	Rank          int     `json:"cmc_rank"`
+	Price         float64 `json:"price"`
+	Volume24h     float64 `json:"volume_24h"`
+	PercentChange float64 `json:"percent_change"`
+}
+
+// PegMonitor is the peg health of every stablecoin tracked, largest
+// deviation first, and the newest alerts, newest first. Threshold is the
+// deviation in percent at which a stablecoin counts as depegged.
+type PegMonitor struct {
+	Threshold   float64      `json:"threshold"`
+	Updated     *time.Time   `json:"updated,omitempty"`
+	Stablecoins []Stablecoin `json:"stablecoins"`
+	Alerts      []PegAlert   `json:"alerts"`
+	Caller
+}
+
+// Stablecoin is one coin pegged to a currency and its deviation from the
+// peg, Peg units of PegCurrency, at every snapshot since it was first seen.
+// Depegged is set from the check that breached the threshold, DepeggedSince,
+// until one that did not. Stale is set when the coin was missing from the
+// newest check. History is only set when a single stablecoin is shown.
+type Stablecoin struct {
+	ID            int        `json:"id"`
+	Name          string     `json:"name"`
+	Symbol        string     `json:"symbol"`
+	Slug          string     `json:"slug"`
+	CoinGeckoID   string     `json:"coingecko_id,omitempty"`
+	Peg           float64    `json:"peg"`
+	PegCurrency   string     `json:"peg_currency"`
+	Latest        PegPoint   `json:"latest"`
+	Depegged      bool       `json:"depegged"`
+	DepeggedSince *time.Time `json:"depegged_since,omitempty"`
+	Stale         bool       `json:"stale,omitempty"`
+	History       []PegPoint `json:"history,omitempty"`
+	Caller
+}
+
+// PegPoint is the price of a stablecoin in its peg currency at one check on
+// each provider and its deviation from the peg in percent, negative below
+// it. The CoinGecko
+// fields are absent when CoinGecko had no price. Deviation is the larger of
+// the two deviations by magnitude.
+type PegPoint struct {
+	Time               time.Time `json:"time"`
+	CMCPrice           float64   `json:"coinmarketcap_price"`
+	CMCDeviation       float64   `json:"coinmarketcap_deviation"`
+	CoinGeckoPrice     *float64  `json:"coingecko_price,omitempty"`
+	CoinGeckoDeviation *float64  `json:"coingecko_deviation,omitempty"`
+	Deviation          float64   `json:"deviation"`
+}
+
+// PegAlert is a stablecoin crossing the threshold. State is depegged when
+// it broke its peg and recovered when it came back; Provider is the one
+// with the larger deviation.
+type PegAlert struct {
+	Time      time.Time `json:"time"`
+	CoinID    int       `json:"coin_id"`
+	Name      string    `json:"name"`
+	Symbol    string    `json:"symbol"`
+	State     string    `json:"state"`
+	Provider  string    `json:"provider"`
+	Deviation float64   `json:"deviation"`
+	Threshold float64   `json:"threshold"`
+}